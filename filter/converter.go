@@ -1,13 +1,17 @@
 package filter
 
 import (
+	"bytes"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"io"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var numericOperatorMap = map[string]string{
@@ -41,6 +45,25 @@ type Converter struct {
 	}
 	emptyCondition  string
 	placeholderName string
+	fieldTypes      map[string]FieldType
+
+	jsonbContainment bool
+	jsonPath         bool
+	implicitArrays   bool
+	mongoNulls       bool
+
+	knownColumns []string
+	existsPolicy ExistsPolicy
+
+	textSearchColumns []string
+	textSearchVector  string
+
+	maxLimit        int64
+	writableColumns []string
+	table           string
+	defaultNulls    NullsOrder
+	collation       string
+	collations      []string
 
 	once sync.Once
 }
@@ -67,11 +90,7 @@ func NewConverter(options ...Option) (*Converter, error) {
 	return converter, nil
 }
 
-// Convert converts a MongoDB filter query into SQL conditions and values.
-//
-// startAtParameterIndex is the index to start the parameter numbering at.
-// Passing X will make the first indexed parameter $X, the second $X+1, and so on.
-func (c *Converter) Convert(query []byte, startAtParameterIndex int) (conditions string, values []any, err error) {
+func (c *Converter) setDefaults() {
 	c.once.Do(func() {
 		if c.emptyCondition == "" {
 			c.emptyCondition = "FALSE"
@@ -80,6 +99,16 @@ func (c *Converter) Convert(query []byte, startAtParameterIndex int) (conditions
 			c.placeholderName = defaultPlaceholderName
 		}
 	})
+}
+
+// Convert converts a MongoDB filter query into SQL conditions and values.
+//
+// startAtParameterIndex is the index to start the parameter numbering at.
+// Passing X will make the first indexed parameter $X, the second $X+1, and so on.
+//
+// A json.RawMessage can be passed as query directly.
+func (c *Converter) Convert(query []byte, startAtParameterIndex int) (conditions string, values []any, err error) {
+	c.setDefaults()
 
 	if startAtParameterIndex < 1 {
 		return "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
@@ -90,16 +119,49 @@ func (c *Converter) Convert(query []byte, startAtParameterIndex int) (conditions
 	}
 
 	var mongoFilter map[string]any
-	err = json.Unmarshal(query, &mongoFilter)
+	err = decodeJSON(bytes.NewReader(query), &mongoFilter)
 	if err != nil {
 		return "", nil, err
 	}
 
-	if len(mongoFilter) == 0 {
+	return c.ConvertMap(mongoFilter, startAtParameterIndex)
+}
+
+// ConvertReader is like [Converter.Convert] but reads the MongoDB filter query from r.
+func (c *Converter) ConvertReader(r io.Reader, startAtParameterIndex int) (conditions string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+
+	var mongoFilter map[string]any
+	err = decodeJSON(r, &mongoFilter)
+	if err == io.EOF {
+		return c.emptyCondition, nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	return c.ConvertMap(mongoFilter, startAtParameterIndex)
+}
+
+// ConvertMap is like [Converter.Convert] but accepts an already decoded MongoDB filter query.
+//
+// Besides the types produced by encoding/json, values in the filter can be Go native
+// types such as int, int64, time.Time and slices like []string. The filter is not modified.
+func (c *Converter) ConvertMap(filter map[string]any, startAtParameterIndex int) (conditions string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+
+	if len(filter) == 0 {
 		return c.emptyCondition, nil, nil
 	}
 
-	conditions, values, err = c.convertFilter(mongoFilter, startAtParameterIndex)
+	conditions, values, err = c.convertFilter(filter, startAtParameterIndex, false)
 	if err != nil {
 		return "", nil, err
 	}
@@ -107,7 +169,9 @@ func (c *Converter) Convert(query []byte, startAtParameterIndex int) (conditions
 	return conditions, values, nil
 }
 
-func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string, []any, error) {
+// convertFilter converts filter into SQL conditions. elemJSONB is true when the placeholder
+// refers to the elements of a JSONB array (see $elemMatch), instead of a Postgres array.
+func (c *Converter) convertFilter(filter map[string]any, paramIndex int, elemJSONB bool) (string, []any, error) {
 	var conditions []string
 	var values []any
 
@@ -121,9 +185,20 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 	}
 	sort.Strings(keys)
 
+	// With WithJSONPath all conditions on nested fields are combined into one jsonb_path_exists.
+	jsonPath := &jsonPath{c: c}
+	var jsonPathPredicates []string
+
 	for _, key := range keys {
 		value := filter[key]
 
+		if c.jsonPath && c.nestedColumn != "" {
+			if predicate, ok := jsonPath.condition(key, value); ok {
+				jsonPathPredicates = append(jsonPathPredicates, predicate)
+				continue
+			}
+		}
+
 		switch key {
 		case "$or", "$and", "$nor":
 			opConditions, ok := anyToSliceMapAny(value)
@@ -136,7 +211,7 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 
 			inner := []string{}
 			for _, orCondition := range opConditions {
-				innerConditions, innerValues, err := c.convertFilter(orCondition, paramIndex)
+				innerConditions, innerValues, err := c.convertFilter(orCondition, paramIndex, elemJSONB)
 				if err != nil {
 					return "", nil, err
 				}
@@ -145,7 +220,7 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 				values = append(values, innerValues...)
 			}
 			if key == "$nor" {
-				conditions = append(conditions, "NOT ("+strings.Join(inner, " OR ")+")")
+				conditions = append(conditions, c.not("("+strings.Join(inner, " OR ")+")"))
 			} else {
 				op := "AND"
 				if key == "$or" {
@@ -162,7 +237,7 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 			if !ok {
 				return "", nil, fmt.Errorf("invalid value for $not operator (must be object): %v", value)
 			}
-			innerConditions, innerValues, err := c.convertFilter(vv, paramIndex)
+			innerConditions, innerValues, err := c.convertFilter(vv, paramIndex, elemJSONB)
 			if err != nil {
 				return "", nil, err
 			}
@@ -171,6 +246,22 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 			// make the whole inner condition NULL. And NOT NULL is still a falsy value, so we need to check for NULL explicitly.
 			conditions = append(conditions, fmt.Sprintf("(NOT COALESCE(%s, FALSE))", innerConditions))
 			values = append(values, innerValues...)
+		case "$expr":
+			condition, exprValues, err := c.convertExpr(value, paramIndex)
+			if err != nil {
+				return "", nil, err
+			}
+			paramIndex += len(exprValues)
+			conditions = append(conditions, condition)
+			values = append(values, exprValues...)
+		case "$text":
+			t, err := c.parseTextSearch(value)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, t.condition(c, paramIndex))
+			paramIndex++
+			values = append(values, t.search)
 		default:
 			if !isValidPostgresIdentifier(key) {
 				return "", nil, fmt.Errorf("invalid column name: %s", key)
@@ -179,6 +270,25 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 				return "", nil, ColumnNotAllowedError{Column: key}
 			}
 
+			// This turns Extended JSON objects like {"$date": "..."} into values, so they
+			// aren't seen as operator objects below.
+			value, err := normalizeValue(value)
+			if err != nil {
+				return "", nil, err
+			}
+
+			// Arrays and embedded documents, like {"position": {"x": 1, "y": 2}}, are compared as a whole.
+			if isComposite(value) {
+				condition, value, err := c.compareComposite(key, "=", value, paramIndex, elemJSONB)
+				if err != nil {
+					return "", nil, err
+				}
+				conditions = append(conditions, condition)
+				paramIndex++
+				values = append(values, value)
+				continue
+			}
+
 			switch v := value.(type) {
 			case map[string]any:
 				if len(v) == 0 {
@@ -198,35 +308,110 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 					case "$and":
 						return "", nil, fmt.Errorf("$and as scalar operator not supported")
 					case "$not":
-						return "", nil, fmt.Errorf("$not as scalar operator not supported")
+						innerConditions, innerValues, err := c.convertNot(key, v[operator], paramIndex, elemJSONB)
+						if err != nil {
+							return "", nil, err
+						}
+						paramIndex += len(innerValues)
+						inner = append(inner, innerConditions)
+						values = append(values, innerValues...)
 					case "$in", "$nin":
-						if !isScalarSlice(v[operator]) {
+						// Don't write the normalized value back into v, the filter might be owned by the caller.
+						value, err := normalizeValue(v[operator])
+						if err != nil {
+							return "", nil, err
+						}
+						value, err = c.fieldValue(key, value)
+						if err != nil {
+							return "", nil, err
+						}
+						if !isScalarSlice(value) {
 							return "", nil, fmt.Errorf("invalid value for $in operator (must array of primatives): %v", v[operator])
 						}
-						neg := ""
-						if operator == "$nin" {
+						if operator == "$in" && c.canUseContainment(key, value) {
+							// This will for example become:
+							//
+							//   ("meta" @> $1::jsonb OR "meta" @> $2::jsonb)
+							//
+							// Each of which can use a GIN index on the JSONB column.
+							elements := value.([]any)
+							or := make([]string, 0, len(elements))
+							for _, e := range elements {
+								condition, docs, err := c.containment(key, e, paramIndex)
+								if err != nil {
+									return "", nil, err
+								}
+								or = append(or, condition)
+								paramIndex += len(docs)
+								values = append(values, docs...)
+							}
+							inner = append(inner, "("+strings.Join(or, " OR ")+")")
+							continue
+						}
+						cast := c.jsonbCast(key, sliceCast(value), elemJSONB)
+						if cast == "numeric" {
+							// Numbers keep being compared as text, only dates, UUIDs and booleans need a cast to compare correctly.
+							cast = ""
+						}
+						in := func(text, jsonb string) string {
+							column := castValue(text, jsonb, cast)
+							if cast == "" {
+								column = c.collate(key, column, value)
+							}
+							if c.mongoNulls && containsNil(value) {
+								// Like in MongoDB, null in the list matches fields that are null or missing.
+								return fmt.Sprintf("%s = ANY($%d) OR %s IS NULL", column, paramIndex, column)
+							}
+							return fmt.Sprintf("%s = ANY($%d)", column, paramIndex)
+						}
+						if c.matchArrays(key) {
+							inner = append(inner, c.arrayMatch(key, operator == "$nin", func(text, jsonb string) string {
+								return "(" + in(text, jsonb) + ")"
+							}))
+						} else if operator == "$nin" {
 							// `column != ANY(...)` does not work, so we need to do `NOT column = ANY(...)` instead.
-							neg = "NOT "
+							inner = append(inner, "("+c.not(in(c.castText(key, cast, elemJSONB), c.columnName(key, false)))+")")
+						} else {
+							inner = append(inner, "("+in(c.castText(key, cast, elemJSONB), c.columnName(key, false))+")")
 						}
-						inner = append(inner, fmt.Sprintf("(%s%s = ANY($%d))", neg, c.columnName(key, true), paramIndex))
 						paramIndex++
 						if c.arrayDriver != nil {
-							v[operator] = c.arrayDriver(v[operator])
+							value = c.arrayDriver(value)
 						}
-						values = append(values, v[operator])
+						values = append(values, value)
 					case "$exists":
 						// $exists only works on jsonb columns, so we need to check if the key is in the JSONB data first.
 						if !c.isNestedColumn(key) {
-							// There is no way in Postgres to check if a column exists on a table.
-							return "", nil, fmt.Errorf("$exists operator not supported on non-nested jsonb columns")
+							condition, err := c.columnExists(key, existsValue(v[operator]))
+							if err != nil {
+								return "", nil, err
+							}
+							inner = append(inner, condition)
+							continue
 						}
 						neg := ""
-						if v[operator] == false {
+						if !existsValue(v[operator]) {
 							neg = "NOT "
 						}
-						inner = append(inner, fmt.Sprintf("(%sjsonb_path_match(%s, 'exists($.%s)'))", neg, c.nestedColumn, key))
+						exists, path := c.fieldExists(key, paramIndex)
+						inner = append(inner, fmt.Sprintf("(%s%s)", neg, exists))
+						paramIndex++
+						values = append(values, path)
+					case "$near", "$nearSphere", "$geoWithin", "$geoIntersects":
+						condition, geoValues, err := c.geoCondition(key, operator, v, paramIndex)
+						if err != nil {
+							return "", nil, err
+						}
+						paramIndex += len(geoValues)
+						inner = append(inner, condition)
+						values = append(values, geoValues...)
+					case "$maxDistance", "$minDistance":
+						// These are options of $near, see geoNear.
+						if v["$near"] == nil && v["$nearSphere"] == nil {
+							return "", nil, fmt.Errorf("%s operator requires $near or $nearSphere", operator)
+						}
 					case "$elemMatch":
-						innerConditions, innerValues, err := c.convertFilter(map[string]any{c.placeholderName: v[operator]}, paramIndex)
+						innerConditions, innerValues, err := c.convertFilter(map[string]any{c.placeholderName: v[operator]}, paramIndex, c.isNestedColumn(key))
 						if err != nil {
 							return "", nil, err
 						}
@@ -256,7 +441,10 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 
 						inner = append(inner, fmt.Sprintf("(%s = %s)", c.columnName(key, true), c.columnName(vv, true)))
 					default:
-						value := v[operator]
+						value, err := normalizeValue(v[operator])
+						if err != nil {
+							return "", nil, err
+						}
 						isNumericOperator := false
 						op, ok := textOperatorMap[operator]
 						if !ok {
@@ -268,7 +456,8 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 						}
 
 						// If the value is a map with a $field key, we need to compare the column to another column.
-						if vv, ok := value.(map[string]any); ok {
+						// Maps without any operators are embedded documents, which are compared below.
+						if vv, ok := value.(map[string]any); ok && !isDocument(vv) {
 							field, ok := vv["$field"].(string)
 							if !ok || len(vv) > 1 {
 								return "", nil, fmt.Errorf("invalid value for %s operator (must be object with $field key only): %v", operator, value)
@@ -277,36 +466,84 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 							left := c.columnName(key, true)
 							right := c.columnName(field, true)
 
-							if isNumericOperator {
-								if c.isNestedColumn(key) {
-									left = fmt.Sprintf("(%s)::numeric", left)
-								}
-								if c.isNestedColumn(field) {
-									right = fmt.Sprintf("(%s)::numeric", right)
-								}
+							if c.fieldTypes[key] == FieldTypeTimestamp && c.isNestedColumn(key) {
+								left = c.castColumn(key, "timestamptz")
+							} else if isNumericOperator && c.isNestedColumn(key) {
+								left = fmt.Sprintf("(%s)::numeric", left)
+							}
+							if c.fieldTypes[field] == FieldTypeTimestamp && c.isNestedColumn(field) {
+								right = c.castColumn(field, "timestamptz")
+							} else if isNumericOperator && c.isNestedColumn(field) {
+								right = fmt.Sprintf("(%s)::numeric", right)
 							}
 
-							inner = append(inner, fmt.Sprintf("(%s %s %s)", left, op, right))
+							inner = append(inner, fmt.Sprintf("(%s %s %s)", left, c.nullSafeOperator(op, value), right))
 						} else {
+							if isComposite(value) && (op == "=" || op == "!=") {
+								condition, value, err := c.compareComposite(key, op, value, paramIndex, elemJSONB)
+								if err != nil {
+									return "", nil, err
+								}
+								inner = append(inner, condition)
+								paramIndex++
+								values = append(values, value)
+								continue
+							}
+
 							// Prevent cryptic errors like:
 							// 	 unexpected error: sql: converting argument $1 type: unsupported type []interface {}, a slice of interface
 							if !isScalar(value) {
 								return "", nil, fmt.Errorf("invalid comparison value (must be a primitive): %v", value)
 							}
+							if op != "~*" {
+								value, err = c.fieldValue(key, value)
+								if err != nil {
+									return "", nil, err
+								}
+							}
+							if operator == "$eq" && c.canUseContainment(key, value) {
+								condition, docs, err := c.containment(key, value, paramIndex)
+								if err != nil {
+									return "", nil, err
+								}
+								inner = append(inner, "("+condition+")")
+								paramIndex += len(docs)
+								values = append(values, docs...)
+								continue
+							}
 
 							// If we aren't comparing columns, and the field is a numeric scalar, we also see = ($eq) and != ($ne) as numeric operators.
 							// This way we can use ::numeric on jsonb values to prevent getting postgres errors like:
 							//   ERROR:  operator does not exist: text = numeric
-							if isNumeric(value) && !isNumericOperator {
+							// The same goes for dates (::timestamptz) and UUIDs (::uuid).
+							cast := c.jsonbCast(key, jsonbCast(value), elemJSONB)
+							if cast != "" && !isNumericOperator {
 								if op == "=" || op == "!=" {
 									isNumericOperator = true
 								}
 							}
 
-							if isNumericOperator && isNumeric(value) && c.isNestedColumn(key) {
-								inner = append(inner, fmt.Sprintf("((%s)::numeric %s $%d)", c.columnName(key, true), op, paramIndex))
+							if !isNumericOperator {
+								cast = ""
+							}
+
+							if c.matchArrays(key) {
+								// $ne matches arrays without any element that is equal to the value.
+								negate := op == "!="
+								if negate {
+									op = "="
+								}
+								op = c.nullSafeOperator(op, value)
+								inner = append(inner, c.arrayMatch(key, negate, func(text, jsonb string) string {
+									if cast == "" {
+										return fmt.Sprintf("(%s %s $%d)", c.collate(key, text, value), op, paramIndex)
+									}
+									return fmt.Sprintf("(%s %s $%d)", castValue(text, jsonb, cast), op, paramIndex)
+								}))
+							} else if cast != "" {
+								inner = append(inner, fmt.Sprintf("(%s %s $%d)", castValue(c.castText(key, cast, elemJSONB), c.columnName(key, false), cast), c.nullSafeOperator(op, value), paramIndex))
 							} else {
-								inner = append(inner, fmt.Sprintf("(%s %s $%d)", c.columnName(key, true), op, paramIndex))
+								inner = append(inner, fmt.Sprintf("(%s %s $%d)", c.collate(key, c.columnName(key, true), value), c.nullSafeOperator(op, value), paramIndex))
 							}
 							paramIndex++
 							values = append(values, value)
@@ -328,8 +565,11 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 						break
 					}
 				}
-				if isNestedColumn {
-					conditions = append(conditions, fmt.Sprintf("(jsonb_path_match(%s, 'exists($.%s)') AND %s IS NULL)", c.nestedColumn, key, c.columnName(key, true)))
+				if isNestedColumn && !c.mongoNulls {
+					exists, path := c.fieldExists(key, paramIndex)
+					conditions = append(conditions, fmt.Sprintf("(%s AND %s IS NULL)", exists, c.columnName(key, true)))
+					paramIndex++
+					values = append(values, path)
 				} else {
 					conditions = append(conditions, fmt.Sprintf("(%s IS NULL)", c.columnName(key, true)))
 				}
@@ -339,11 +579,34 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 				if !isScalar(value) {
 					return "", nil, fmt.Errorf("invalid comparison value (must be a primitive): %v", value)
 				}
-				if isNumeric(value) && c.isNestedColumn(key) {
-					// If the value is numeric and the column is a nested JSONB column, we need to cast the column to numeric.
-					conditions = append(conditions, fmt.Sprintf("((%s)::numeric = $%d)", c.columnName(key, true), paramIndex))
+				value, err = c.fieldValue(key, value)
+				if err != nil {
+					return "", nil, err
+				}
+				if c.canUseContainment(key, value) {
+					condition, docs, err := c.containment(key, value, paramIndex)
+					if err != nil {
+						return "", nil, err
+					}
+					conditions = append(conditions, "("+condition+")")
+					paramIndex += len(docs)
+					values = append(values, docs...)
+					continue
+				}
+
+				// If the value is numeric (or a date) and the column is a nested JSONB column, we need to cast the column.
+				cast := c.jsonbCast(key, jsonbCast(value), elemJSONB)
+				if c.matchArrays(key) {
+					conditions = append(conditions, c.arrayMatch(key, false, func(text, jsonb string) string {
+						if cast == "" {
+							return fmt.Sprintf("(%s = $%d)", c.collate(key, text, value), paramIndex)
+						}
+						return fmt.Sprintf("(%s = $%d)", castValue(text, jsonb, cast), paramIndex)
+					}))
+				} else if cast != "" {
+					conditions = append(conditions, fmt.Sprintf("(%s = $%d)", castValue(c.castText(key, cast, elemJSONB), c.columnName(key, false), cast), paramIndex))
 				} else {
-					conditions = append(conditions, fmt.Sprintf("(%s = $%d)", c.columnName(key, true), paramIndex))
+					conditions = append(conditions, fmt.Sprintf("(%s = $%d)", c.collate(key, c.columnName(key, true), value), paramIndex))
 				}
 				paramIndex++
 				values = append(values, value)
@@ -351,6 +614,15 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 		}
 	}
 
+	if len(jsonPathPredicates) > 0 {
+		condition, jsonPathValues, err := jsonPath.sql(jsonPathPredicates, paramIndex)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+		values = append(values, jsonPathValues...)
+	}
+
 	result := strings.Join(conditions, " AND ")
 	if len(conditions) > 1 {
 		result = "(" + result + ")"
@@ -360,6 +632,10 @@ func (c *Converter) convertFilter(filter map[string]any, paramIndex int) (string
 
 func (c *Converter) columnName(column string, jsonFieldAsText bool) string {
 	if column == c.placeholderName {
+		if !jsonFieldAsText {
+			// Only used for the elements of JSONB arrays.
+			return fmt.Sprintf(`%q`, column)
+		}
 		return fmt.Sprintf(`%q::text`, column)
 	}
 	if c.nestedColumn == "" {
@@ -376,6 +652,292 @@ func (c *Converter) columnName(column string, jsonFieldAsText bool) string {
 	return fmt.Sprintf(`%q->'%s'`, c.nestedColumn, column)
 }
 
+// columnExists converts $exists for a regular column, using the columns declared with
+// WithKnownColumns and the policy set with WithExistsPolicy.
+func (c *Converter) columnExists(column string, exists bool) (string, error) {
+	if c.knownColumns == nil {
+		// There is no way in Postgres to check if a column exists on a table.
+		return "", fmt.Errorf("$exists operator not supported on non-nested jsonb columns")
+	}
+	known := false
+	for _, knownColumn := range c.knownColumns {
+		if knownColumn == column {
+			known = true
+			break
+		}
+	}
+	if known && c.existsPolicy == ExistsNotNull {
+		if exists {
+			return fmt.Sprintf("(%s IS NOT NULL)", c.columnName(column, true)), nil
+		}
+		return fmt.Sprintf("(%s IS NULL)", c.columnName(column, true)), nil
+	}
+	// Columns that aren't known don't exist, so this doesn't need to (and can't) reference the column.
+	if known == exists {
+		return "TRUE", nil
+	}
+	return "FALSE", nil
+}
+
+// convertNot converts the field level $not operator, which negates an object with operators
+// like {"$regex": "^bot"}, or a regular expression literal like "/^bot/i".
+func (c *Converter) convertNot(column string, value any, paramIndex int, elemJSONB bool) (string, []any, error) {
+	var condition string
+	var values []any
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 || isDocument(v) {
+			return "", nil, fmt.Errorf("invalid value for $not operator (must be object with operators or a regular expression): %v", value)
+		}
+		var err error
+		condition, values, err = c.convertFilter(map[string]any{column: v}, paramIndex, elemJSONB)
+		if err != nil {
+			return "", nil, err
+		}
+	case string:
+		pattern, op, err := parseRegexLiteral(v)
+		if err != nil {
+			return "", nil, err
+		}
+		match := func(text, jsonb string) string {
+			return fmt.Sprintf("(%s %s $%d)", c.collate(column, text, pattern), op, paramIndex)
+		}
+		if c.matchArrays(column) {
+			condition = c.arrayMatch(column, false, match)
+		} else {
+			condition = match(c.columnName(column, true), c.columnName(column, false))
+		}
+		values = []any{pattern}
+	default:
+		return "", nil, fmt.Errorf("invalid value for $not operator (must be object with operators or a regular expression): %v", value)
+	}
+	// Just like the top level $not, a missing jsonb field makes the condition NULL, which should match.
+	return fmt.Sprintf("(NOT COALESCE(%s, FALSE))", condition), values, nil
+}
+
+// compareComposite returns the condition comparing column with an array or embedded document
+// using op (= or !=), and the value to bind for it.
+//
+// Fields in the nested JSONB column are compared with a JSONB value, so {"keys": [1, 3]} becomes
+// ("meta"->'keys' = $1::jsonb). Array columns are compared with an array bound using the array driver.
+func (c *Converter) compareComposite(column, op string, value any, paramIndex int, elemJSONB bool) (string, any, error) {
+	isJSONB := c.isNestedColumn(column)
+	if column == c.placeholderName {
+		isJSONB = elemJSONB
+	}
+	if isJSONB {
+		doc, err := jsonbValue(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid comparison value: %w", err)
+		}
+		return fmt.Sprintf("(%s %s $%d::jsonb)", c.columnName(column, false), c.nullSafeOperator(op, value), paramIndex), doc, nil
+	}
+
+	if _, ok := value.(map[string]any); ok {
+		return "", nil, fmt.Errorf("invalid comparison value (embedded documents can only be compared with nested jsonb fields): %v", value)
+	}
+	if column == c.placeholderName || !isScalarSlice(value) {
+		return "", nil, fmt.Errorf("invalid comparison value (must be a primitive or an array of primitives): %v", value)
+	}
+	if c.arrayDriver == nil {
+		// Drivers like lib/pq can't bind a []any, which would only fail when running the query.
+		return "", nil, fmt.Errorf("invalid comparison value (arrays can only be compared with columns when WithArrayDriver is set): %v", value)
+	}
+	return fmt.Sprintf("(%s %s $%d)", c.columnName(column, true), c.nullSafeOperator(op, value), paramIndex), c.arrayDriver(value), nil
+}
+
+// not returns the negation of condition. With WithMongoNullSemantics a condition that is
+// NULL, for example because the field is NULL or missing, is seen as false so its negation matches.
+func (c *Converter) not(condition string) string {
+	if c.mongoNulls {
+		return fmt.Sprintf("NOT COALESCE(%s, FALSE)", condition)
+	}
+	return "NOT " + condition
+}
+
+// nullSafeOperator returns the operator to compare with value. With WithMongoNullSemantics
+// $ne matches NULL (and missing fields), and comparing with null matches NULL.
+func (c *Converter) nullSafeOperator(op string, value any) string {
+	if !c.mongoNulls {
+		return op
+	}
+	if op == "!=" {
+		return "IS DISTINCT FROM"
+	}
+	if op == "=" && value == nil {
+		return "IS NOT DISTINCT FROM"
+	}
+	return op
+}
+
+// timestampRegexp is used to check if a JSONB text value can be cast to timestamptz
+// without failing the whole query. It only allows ISO-8601 like values with a date that exists:
+// the days of each month, February 29 in leap years only, no year 0 and the time zone offsets
+// Postgres accepts (up to 15 hours).
+const timestampRegexp = `^(` + timestampYear + `-((0[13578]|1[02])-(0[1-9]|[12][0-9]|3[01])|(0[469]|11)-(0[1-9]|[12][0-9]|30)|02-(0[1-9]|1[0-9]|2[0-8]))|` + timestampLeapYear + `-02-29)` +
+	`([T ]([01][0-9]|2[0-3]):[0-5][0-9](:[0-5][0-9](\.[0-9]+)?)?)?(Z|[+-](0[0-9]|1[0-5])(:?[0-5][0-9])?)?$`
+
+const (
+	timestampYear     = `([0-9]{3}[1-9]|[0-9]{2}[1-9]0|[0-9][1-9]00|[1-9]000)`
+	timestampLeapYear = `([0-9]{2}(0[48]|[2468][048]|[13579][26])|(0[48]|[2468][048]|[13579][26])00)`
+)
+
+// jsonbCast returns cast if column is a nested JSONB column that needs to be cast to compare it, or
+// an empty string otherwise.
+func (c *Converter) jsonbCast(column, cast string, elemJSONB bool) string {
+	if !c.isNestedColumn(column) {
+		return ""
+	}
+	if cast == "boolean" && column == c.placeholderName && !elemJSONB {
+		// The elements of a Postgres array are compared as text, there is no JSONB value to check the type of.
+		return ""
+	}
+	return cast
+}
+
+// castColumn returns the text value of a nested JSONB column cast to the given type.
+func (c *Converter) castColumn(column, cast string) string {
+	return castValue(c.columnName(column, true), c.columnName(column, false), cast)
+}
+
+// castText returns the text of column to cast to cast, like columnName(column, true). The
+// elements of JSONB arrays in $elemMatch are unquoted with #>> '{}' when they are cast, as
+// ::text keeps the quotes of JSONB strings.
+func (c *Converter) castText(column, cast string, elemJSONB bool) string {
+	if cast != "" && elemJSONB && column == c.placeholderName {
+		return fmt.Sprintf(`%q #>> '{}'`, column)
+	}
+	return c.columnName(column, true)
+}
+
+// castValue returns text, the text of the JSONB value jsonb, cast to the given type.
+// If cast is empty text is returned as is.
+func castValue(text, jsonb, cast string) string {
+	switch cast {
+	case "":
+		return text
+	case "boolean":
+		// Casting a JSONB string to boolean results in an error, so only cast actual booleans.
+		return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'boolean' THEN (%s)::boolean END)", jsonb, text)
+	case "timestamptz":
+		// Casting an invalid timestamp results in an error, so we make those NULL instead.
+		return fmt.Sprintf("(CASE WHEN %s ~ '%s' THEN (%s)::timestamptz END)", text, timestampRegexp, text)
+	default:
+		return fmt.Sprintf("(%s)::%s", text, cast)
+	}
+}
+
+// matchArrays returns true if conditions on column should also match arrays with a
+// matching element, see [WithImplicitArrayMatching].
+func (c *Converter) matchArrays(column string) bool {
+	return c.implicitArrays && c.isNestedColumn(column) && column != c.placeholderName
+}
+
+// arrayMatch returns a condition on the nested JSONB column that is true if the field matches
+// condition, or if the field is an array with an element that matches condition. condition
+// is called with the text and JSONB expressions of the value to check.
+//
+// If negate is true the condition is negated, arrays then match if none of their elements match.
+func (c *Converter) arrayMatch(column string, negate bool, condition func(text, jsonb string) string) string {
+	// This will for example become:
+	//
+	//   (CASE WHEN jsonb_typeof("meta"->'hats') = 'array'
+	//     THEN EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder" #>> '{}' = $1))
+	//     ELSE ("meta"->>'hats' = $1) END)
+	//
+	// #>> '{}' returns the text of a JSONB value, like ->> does for fields.
+	element := fmt.Sprintf("%q", c.placeholderName)
+	elements := fmt.Sprintf("EXISTS (SELECT 1 FROM jsonb_array_elements(%s) AS %s WHERE %s)", c.columnName(column, false), c.placeholderName, condition(element+" #>> '{}'", element))
+	field := condition(c.columnName(column, true), c.columnName(column, false))
+	if negate {
+		elements = "NOT " + elements
+		field = c.not(field)
+	}
+	return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'array' THEN %s ELSE %s END)", c.columnName(column, false), elements, field)
+}
+
+// fieldValue converts a value from the filter to the type declared with WithFieldTypes.
+func (c *Converter) fieldValue(column string, v any) (any, error) {
+	if c.fieldTypes[column] != FieldTypeTimestamp {
+		return v, nil
+	}
+	switch vv := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, vv)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", vv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp value for %s: %v", column, v)
+		}
+		return t, nil
+	case []any:
+		result := make([]any, len(vv))
+		for i, e := range vv {
+			var err error
+			if result[i], err = c.fieldValue(column, e); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	default:
+		return v, nil
+	}
+}
+
+// canUseContainment returns true if comparing column with value can be done using the
+// JSONB containment operator without changing the semantics of the comparison.
+// value can be a single value or a non-empty slice of values (for $in).
+func (c *Converter) canUseContainment(column string, value any) bool {
+	if !c.jsonbContainment || !c.isNestedColumn(column) || column == c.placeholderName {
+		return false
+	}
+	if elements, ok := value.([]any); ok {
+		if len(elements) == 0 {
+			return false
+		}
+		for _, e := range elements {
+			if !c.canUseContainment(column, e) {
+				return false
+			}
+		}
+		return true
+	}
+	switch v := value.(type) {
+	case string:
+		// ->> also matches stored numbers and booleans with the same text, like "20" and 20.
+		if _, err := strconv.ParseFloat(v, 64); err == nil || v == "true" || v == "false" {
+			return false
+		}
+		// Containment compares strings exactly, without the collation.
+		return c.collation == ""
+	case bool:
+		// Numbers are compared as numeric, so containment would stop matching "1" and 1.0.
+		// NULL doesn't match missing keys with containment.
+		return true
+	default:
+		return false
+	}
+}
+
+// containment returns a condition checking if the nested JSONB column contains column with
+// value, and the JSON documents to bind for it.
+func (c *Converter) containment(column string, value any, paramIndex int) (string, []any, error) {
+	doc, err := json.Marshal(map[string]any{column: value})
+	if err != nil {
+		return "", nil, err
+	}
+	if !c.matchArrays(column) {
+		return fmt.Sprintf("%q @> $%d::jsonb", c.nestedColumn, paramIndex), []any{string(doc)}, nil
+	}
+	// With WithImplicitArrayMatching the field can also be an array containing the value.
+	arrayDoc, err := json.Marshal(map[string]any{column: []any{value}})
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%q @> $%d::jsonb OR %q @> $%d::jsonb", c.nestedColumn, paramIndex, c.nestedColumn, paramIndex+1), []any{string(doc), string(arrayDoc)}, nil
+}
+
 func (c *Converter) isColumnAllowed(column string) bool {
 	for _, disallowed := range c.disallowedColumns {
 		if disallowed == column {
@@ -396,6 +958,71 @@ func (c *Converter) isColumnAllowed(column string) bool {
 	return false
 }
 
+// isColumnWritable returns true if column can be updated with ConvertUpdate. Fields in the nested
+// JSONB column are writable if the nested column is writable.
+func (c *Converter) isColumnWritable(column string) bool {
+	for _, disallowed := range c.disallowedColumns {
+		if disallowed == column {
+			return false
+		}
+	}
+	for _, writable := range c.writableColumns {
+		if writable == column || (writable == c.nestedColumn && c.isNestedColumn(column)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCollationAllowed returns true if collation was declared with WithCollations or WithDefaultCollation.
+func (c *Converter) isCollationAllowed(collation string) bool {
+	if collation != "" && collation == c.collation {
+		return true
+	}
+	for _, allowed := range c.collations {
+		if allowed == collation {
+			return true
+		}
+	}
+	return false
+}
+
+// collate adds the collation set with WithDefaultCollation to the text expression of column if
+// value is a string or a list of strings. Other values aren't compared as text, and columns that
+// aren't text, like timestamps or UUIDs, don't support collations.
+func (c *Converter) collate(column, expression string, value any) string {
+	if c.collation == "" || !c.isTextColumn(column) {
+		return expression
+	}
+	switch v := value.(type) {
+	case string:
+	case []any:
+		if len(v) == 0 {
+			return expression
+		}
+		for _, e := range v {
+			if _, ok := e.(string); !ok {
+				return expression
+			}
+		}
+	default:
+		return expression
+	}
+	return expression + " COLLATE " + quoteCollation(c.collation)
+}
+
+// fieldExists returns a condition checking if field exists in the nested JSONB column, and the
+// jsonpath to bind for it.
+func (c *Converter) fieldExists(field string, paramIndex int) (string, string) {
+	return fmt.Sprintf("jsonb_path_exists(%q, $%d::jsonpath)", c.nestedColumn, paramIndex), fmt.Sprintf("$.%q", field)
+}
+
+// isTextColumn returns true if column is text: fields in the nested JSONB column, which are
+// compared with ->>, and columns declared as text with WithFieldTypes.
+func (c *Converter) isTextColumn(column string) bool {
+	return c.isNestedColumn(column) || c.fieldTypes[column] == FieldTypeText
+}
+
 func (c *Converter) isNestedColumn(column string) bool {
 	if c.nestedColumn == "" {
 		return false
@@ -410,74 +1037,401 @@ func (c *Converter) isNestedColumn(column string) bool {
 
 // ConvertOrderBy converts a JSON object with field names and sort directions
 // into a PostgreSQL ORDER BY clause. The JSON object should have keys with values
-// of 1 (ASC) or -1 (DESC).
+// of 1 or "asc" (ASC) and -1 or "desc" (DESC). The object form {"direction": -1, "nulls": "first"}
+// also sets where NULL values are sorted, the default is set with [WithDefaultNulls].
 //
-// For JSONB fields, it generates clauses that handle both numeric and text sorting.
+// For JSONB fields, it generates clauses that handle both numeric and text sorting, unless the
+// type of the field is declared with [WithFieldTypes] or with a hint like
+// {"score": {"direction": -1, "type": "numeric"}}.
 //
 // Example: {"playerCount": -1, "name": 1} -> "playerCount DESC, name ASC"
+//
+// Sorting on {"$meta": "textScore"} needs the $text operator of the filter, use
+// [Converter.ConvertWithOrderBy] for that.
 func (c *Converter) ConvertOrderBy(query []byte) (string, error) {
-	keyValues, err := objectInOrder(query)
+	c.setDefaults()
+
+	orderBy, values, err := c.convertOrderBy(query, nil, 1)
 	if err != nil {
 		return "", err
 	}
+	if len(values) > 0 {
+		return "", fmt.Errorf("sort object with values (like $order) requires ConvertOrderByWithValues")
+	}
+	return orderBy, nil
+}
 
-	parts := make([]string, 0, len(keyValues))
+// ConvertOrderByWithValues is like [Converter.ConvertOrderBy], but also supports sorting on
+// computed values that need parameters, for example:
+//
+//	{"freeSlots": {"$expr": {"$subtract": ["$maxPlayers", "$playerCount"]}, "direction": -1}}
+//	{"status": {"$order": ["live", "starting", "ended"]}}
+//
+// The first sorts on an $expr expression (see [Converter.Convert]), the key is only a name. The
+// second sorts on the position of the value in the list, values that aren't in it come last.
+// The values start at startAtParameterIndex.
+func (c *Converter) ConvertOrderByWithValues(query []byte, startAtParameterIndex int) (orderBy string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+	return c.convertOrderBy(query, nil, startAtParameterIndex)
+}
+
+// ConvertWithOrderBy converts a MongoDB filter query and a sort object at the same time, see
+// [Converter.Convert] and [Converter.ConvertOrderBy]. This allows sorting on the relevance of
+// the $text operator in the filter, for example:
+//
+//	{"score": {"$meta": "textScore"}} -> ts_rank(to_tsvector('english', "title"), websearch_to_tsquery('english', $2)) DESC
+//
+// values contains the values of both the conditions and the ORDER BY clause.
+func (c *Converter) ConvertWithOrderBy(query, sort []byte, startAtParameterIndex int) (conditions, orderBy string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+
+	var mongoFilter map[string]any
+	if len(query) > 0 {
+		if err := decodeJSON(bytes.NewReader(query), &mongoFilter); err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	conditions, values, err = c.ConvertMap(mongoFilter, startAtParameterIndex)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var text *textSearch
+	if value, ok := mongoFilter["$text"]; ok {
+		t, err := c.parseTextSearch(value)
+		if err != nil {
+			return "", "", nil, err
+		}
+		text = &t
+	}
+
+	orderBy, orderByValues, err := c.convertOrderBy(sort, text, startAtParameterIndex+len(values))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return conditions, orderBy, append(values, orderByValues...), nil
+}
+
+// convertOrderBy converts a sort object, text is the $text operator of the filter if any.
+// The values of the ORDER BY clause start at $paramIndex.
+func (c *Converter) convertOrderBy(query []byte, text *textSearch, paramIndex int) (string, []any, error) {
+	keys, values, err := c.sortKeys(query, text, paramIndex)
+	if err != nil {
+		return "", nil, err
+	}
+	return orderByClause(keys), values, nil
+}
+
+// sortKey is a field of a sort object, with the expressions to sort it on.
+type sortKey struct {
+	field       string
+	expressions []string
+	direction   string
+	nulls       string
+	// computed is true for keys that don't sort on the value of the field, like textScore,
+	// these can't be used for keyset pagination.
+	computed bool
+}
+
+// orderByClause returns the ORDER BY clause of keys.
+func orderByClause(keys []sortKey) string {
+	parts := []string{}
+	for _, key := range keys {
+		for _, expression := range key.expressions {
+			part := expression + " " + key.direction
+			if key.nulls != "" {
+				part += " " + key.nulls
+			}
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortKeys converts a sort object into the keys to sort on, see convertOrderBy.
+func (c *Converter) sortKeys(query []byte, text *textSearch, paramIndex int) ([]sortKey, []any, error) {
+	keyValues, err := objectInOrder(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]sortKey, 0, len(keyValues))
+	var values []any
 
 	for _, kv := range keyValues {
 		key, value := kv.Key, kv.Value
 
+		// The key of a textScore sort is only a name, like in a MongoDB projection.
+		if meta, ok := value.(map[string]any); ok && len(meta) == 1 && meta["$meta"] != nil {
+			if meta["$meta"] != "textScore" {
+				return nil, nil, fmt.Errorf("invalid $meta for field %s: %v (must be textScore)", key, meta["$meta"])
+			}
+			if text == nil {
+				return nil, nil, fmt.Errorf("sorting on textScore requires a $text operator in the filter")
+			}
+			keys = append(keys, sortKey{field: key, expressions: []string{text.rank(c, paramIndex)}, direction: "DESC", computed: true})
+			paramIndex++
+			values = append(values, text.search)
+			continue
+		}
+
+		// The key of an $expr sort is only a name as well, the fields are in the expression.
+		if expr, ok := value.(map[string]any); ok && expr["$expr"] != nil {
+			p := &boundValues{paramIndex: paramIndex}
+			expression, err := c.sortExpression(key, expr["$expr"], p)
+			if err != nil {
+				return nil, nil, err
+			}
+			options, err := c.sortOrder(key, value)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, sortKey{field: key, expressions: []string{expression}, direction: options.direction, nulls: options.nulls, computed: true})
+			paramIndex += len(p.values)
+			values = append(values, p.values...)
+			continue
+		}
+
 		if !isValidPostgresIdentifier(key) {
-			return "", fmt.Errorf("invalid column name: %s", key)
+			return nil, nil, fmt.Errorf("invalid column name: %s", key)
 		}
 		if !c.isColumnAllowed(key) {
-			return "", ColumnNotAllowedError{Column: key}
-		}
-
-		// Convert value to number for direction
-		var direction string
-		switch v := value.(type) {
-		case json.Number:
-			if num, err := v.Int64(); err == nil {
-				switch num {
-				case 1:
-					direction = "ASC"
-				case -1:
-					direction = "DESC"
-				default:
-					return "", fmt.Errorf("invalid order direction for field %s: %v (must be 1 or -1)", key, value)
-				}
-			} else {
-				return "", fmt.Errorf("invalid order direction for field %s: %v (must be 1 or -1)", key, value)
+			return nil, nil, ColumnNotAllowedError{Column: key}
+		}
+
+		// {"status": {"$order": ["live", "starting"]}} sorts on the position in the list.
+		if order, ok := value.(map[string]any); ok && order["$order"] != nil {
+			p := &boundValues{paramIndex: paramIndex}
+			expression, err := c.sortPosition(key, order["$order"], p)
+			if err != nil {
+				return nil, nil, err
 			}
-		case float64:
-			switch v {
-			case 1:
-				direction = "ASC"
-			case -1:
-				direction = "DESC"
-			default:
-				return "", fmt.Errorf("invalid order direction for field %s: %v (must be 1 or -1)", key, value)
+			options, err := c.sortOrder(key, value)
+			if err != nil {
+				return nil, nil, err
 			}
-		default:
-			return "", fmt.Errorf("invalid order direction for field %s: %v (must be 1 or -1)", key, value)
+			keys = append(keys, sortKey{field: key, expressions: []string{expression}, direction: options.direction, nulls: options.nulls, computed: true})
+			paramIndex += len(p.values)
+			values = append(values, p.values...)
+			continue
+		}
+
+		// {"location": {"$near": [x, y]}} sorts on the distance to the point.
+		if near, ok := value.(map[string]any); ok && len(near) == 1 && near["$near"] != nil {
+			distance, err := c.geoDistance(key, near["$near"])
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, sortKey{field: key, expressions: []string{distance}, direction: "ASC", nulls: "NULLS LAST", computed: true})
+			continue
+		}
+
+		options, err := c.sortOrder(key, value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fieldType := c.fieldTypes[key]
+		if options.fieldType != "" {
+			fieldType = options.fieldType
 		}
 
-		var fieldClause string
-		if c.isNestedColumn(key) {
-			// For JSONB fields, handle both numeric and text sorting.
-			// We need to use the raw JSONB reference for jsonb_typeof, but columnName() for the actual sorting
-			fieldClause = fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'number' THEN (%s)::numeric END) %s NULLS LAST, %s %s NULLS LAST", c.columnName(key, false), c.columnName(key, true), direction, c.columnName(key, true), direction)
+		var expressions []string
+		if c.isNestedColumn(key) && (fieldType == FieldTypeTimestamp || fieldType == FieldTypeBoolean) {
+			// Values of another type are sorted as NULL instead of failing the query.
+			expressions = []string{c.castColumn(key, string(fieldType))}
+		} else if c.isNestedColumn(key) && fieldType == FieldTypeNumeric {
+			expressions = []string{c.numericSortExpression(key)}
+		} else if c.isNestedColumn(key) && fieldType == FieldTypeText {
+			expressions = []string{c.textSortExpression(key, c.columnName(key, true), options)}
+		} else if c.isNestedColumn(key) {
+			// For JSONB fields of unknown type, handle both numeric and text sorting.
+			expressions = []string{c.numericSortExpression(key), c.textSortExpression(key, c.columnName(key, true), options)}
+		} else if options.collation != "" || options.caseInsensitive || (c.collation != "" && c.isTextColumn(key)) {
+			expressions = []string{c.textSortExpression(key, c.columnName(key, true), options)}
 		} else {
 			// Regular field.
-			fieldClause = fmt.Sprintf(`%s %s NULLS LAST`, c.columnName(key, true), direction)
+			expressions = []string{c.columnName(key, true)}
 		}
 
-		parts = append(parts, fieldClause)
+		// Keyset pagination can't compare values with lower(), so case insensitive keys are computed.
+		keys = append(keys, sortKey{field: key, expressions: expressions, direction: options.direction, nulls: options.nulls, computed: options.caseInsensitive})
 	}
 
-	if len(parts) == 0 {
-		return "", nil
+	return keys, values, nil
+}
+
+// sortOptions are the options of a field in a sort object.
+type sortOptions struct {
+	direction string
+	nulls     string
+	// collation and caseInsensitive are only used for text, see textSortExpression.
+	collation       string
+	caseInsensitive bool
+	// fieldType overrides the type declared with WithFieldTypes for nested fields.
+	fieldType FieldType
+}
+
+// sortOrder returns the options of a sort object value, which is a direction or an object like
+// {"direction": -1, "nulls": "first", "collation": "und-x-icu", "caseInsensitive": true, "type": "text"}.
+// Without nulls the default set with WithDefaultNulls is used.
+func (c *Converter) sortOrder(field string, value any) (sortOptions, error) {
+	nullsOrder := c.defaultNulls
+	var options sortOptions
+	if object, ok := value.(map[string]any); ok {
+		computed := object["$expr"] != nil || object["$order"] != nil
+		for k, v := range object {
+			switch k {
+			case "$expr", "$order":
+				// See sortExpression and sortPosition.
+			case "direction":
+			case "nulls":
+				n, ok := v.(string)
+				if !ok || (NullsOrder(strings.ToLower(n)) != NullsFirst && NullsOrder(strings.ToLower(n)) != NullsLast) {
+					return sortOptions{}, fmt.Errorf("invalid nulls for field %s: %v (must be \"first\" or \"last\")", field, v)
+				}
+				nullsOrder = NullsOrder(strings.ToLower(n))
+			case "collation":
+				collation, ok := v.(string)
+				if !ok || !c.isCollationAllowed(collation) {
+					return sortOptions{}, fmt.Errorf("collation not allowed for field %s: %v", field, v)
+				}
+				if !c.isTextColumn(field) {
+					return sortOptions{}, fmt.Errorf("collation not allowed for field %s: %v (must be a nested field or declared as text with WithFieldTypes)", field, v)
+				}
+				options.collation = collation
+			case "caseInsensitive":
+				caseInsensitive, ok := v.(bool)
+				if !ok {
+					return sortOptions{}, fmt.Errorf("invalid caseInsensitive for field %s: %v (must be true or false)", field, v)
+				}
+				options.caseInsensitive = caseInsensitive
+			case "type":
+				t, _ := v.(string)
+				switch FieldType(t) {
+				case FieldTypeNumeric, FieldTypeTimestamp, FieldTypeBoolean, FieldTypeText:
+					options.fieldType = FieldType(t)
+				default:
+					return sortOptions{}, fmt.Errorf("invalid type for field %s: %v (must be numeric, timestamptz, boolean or text)", field, v)
+				}
+			default:
+				return sortOptions{}, fmt.Errorf("invalid sort option for field %s: %s (must be direction, nulls, collation, caseInsensitive or type)", field, k)
+			}
+		}
+		value = object["direction"]
+		if value == nil && computed {
+			// The direction of an expression is optional.
+			value = json.Number("1")
+		}
+	}
+
+	var err error
+	options.direction, err = sortDirection(field, value)
+	if err != nil {
+		return sortOptions{}, err
+	}
+	options.nulls = "NULLS LAST"
+	if nullsOrder == NullsFirst {
+		options.nulls = "NULLS FIRST"
+	}
+	return options, nil
+}
+
+// sortExpression converts the $expr expression of a sort key, see ConvertOrderByWithValues.
+func (c *Converter) sortExpression(key string, value any, p *boundValues) (string, error) {
+	operand, err := c.exprOperand(value, p)
+	if err != nil {
+		return "", err
+	}
+	if operand.isLiteral {
+		return "", fmt.Errorf("invalid $expr for sort key %s (must be a field or an expression): %v", key, value)
+	}
+	return c.exprSQL(operand, operand.cast, p)
+}
+
+// sortPosition returns the position of column in the list of values of $order, for example:
+//
+//	CASE "status" WHEN $1 THEN 0 WHEN $2 THEN 1 ELSE 2 END
+func (c *Converter) sortPosition(column string, value any, p *boundValues) (string, error) {
+	list, ok := value.([]any)
+	if !ok || len(list) == 0 {
+		return "", fmt.Errorf("invalid value for $order of field %s (must be non-empty array of primitives): %v", column, value)
+	}
+	whens := make([]string, 0, len(list))
+	for i, e := range list {
+		e, err := normalizeValue(e)
+		if err != nil {
+			return "", err
+		}
+		if !isScalar(e) || e == nil {
+			return "", fmt.Errorf("invalid value for $order of field %s (must be non-empty array of primitives): %v", column, value)
+		}
+		if e, err = c.fieldValue(column, e); err != nil {
+			return "", err
+		}
+		whens = append(whens, fmt.Sprintf("WHEN %s THEN %d", p.add(e), i))
+	}
+	expression := c.columnName(column, true)
+	if c.isNestedColumn(column) && c.fieldTypes[column] == FieldTypeTimestamp {
+		expression = c.castColumn(column, "timestamptz")
+	} else {
+		expression = c.collate(column, expression, list)
 	}
+	return fmt.Sprintf("CASE %s %s ELSE %d END", expression, strings.Join(whens, " "), len(list)), nil
+}
+
+// numericSortExpression returns the numeric value of a field in the nested JSONB column to sort
+// on, or NULL if it isn't a number. We need to use the raw JSONB reference for jsonb_typeof, but
+// columnName() for the actual sorting.
+func (c *Converter) numericSortExpression(field string) string {
+	return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'number' THEN (%s)::numeric END)", c.columnName(field, false), c.columnName(field, true))
+}
 
-	return strings.Join(parts, ", "), nil
+// textSortExpression returns the text expression to sort on with the collation and
+// caseInsensitive options. The collation set with WithDefaultCollation is only used for text
+// columns, see isTextColumn.
+func (c *Converter) textSortExpression(field, expression string, options sortOptions) string {
+	if options.caseInsensitive {
+		expression = fmt.Sprintf("lower(%s)", expression)
+	}
+	collation := options.collation
+	if collation == "" && c.isTextColumn(field) {
+		collation = c.collation
+	}
+	if collation != "" {
+		expression += " COLLATE " + quoteCollation(collation)
+	}
+	return expression
+}
+
+// sortDirection returns the direction of a sort value: 1, -1 or one of the strings "asc",
+// "desc", "ascending" and "descending".
+func sortDirection(field string, value any) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		switch {
+		case numberEquals(v, 1):
+			return "ASC", nil
+		case numberEquals(v, -1):
+			return "DESC", nil
+		}
+	case string:
+		switch strings.ToLower(v) {
+		case "asc", "ascending":
+			return "ASC", nil
+		case "desc", "descending":
+			return "DESC", nil
+		}
+	}
+	return "", InvalidOrderDirectionError{Field: field, Value: value}
 }
@@ -2,9 +2,14 @@ package filter_test
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/poki/mongodb-filter-to-postgres/filter"
 )
@@ -33,6 +38,12 @@ func ExampleNewConverter() {
 	// SELECT * FROM users WHERE (("created_at" >= $1) AND ("meta"->>'name' = $2)), 2020-01-01T00:00:00Z, "John"
 }
 
+// timestamptz returns the expression used to safely cast a JSONB text value to timestamptz.
+func timestamptz(column string) string {
+	return `(CASE WHEN ` + column + ` ~ '^(([0-9]{3}[1-9]|[0-9]{2}[1-9]0|[0-9][1-9]00|[1-9]000)-((0[13578]|1[02])-(0[1-9]|[12][0-9]|3[01])|(0[469]|11)-(0[1-9]|[12][0-9]|30)|02-(0[1-9]|1[0-9]|2[0-8]))|([0-9]{2}(0[48]|[2468][048]|[13579][26])|(0[48]|[2468][048]|[13579][26])00)-02-29)` +
+		`([T ]([01][0-9]|2[0-3]):[0-5][0-9](:[0-5][0-9](\.[0-9]+)?)?)?(Z|[+-](0[0-9]|1[0-5])(:?[0-5][0-9])?)?$' THEN (` + column + `)::timestamptz END)`
+}
+
 func TestConverter_Convert(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -55,7 +66,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"age": 30, "name": "John"}`,
 			`(("age" = $1) AND ("name" = $2))`,
-			[]any{float64(30), "John"},
+			[]any{int64(30), "John"},
 			nil,
 		},
 		{
@@ -63,7 +74,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"players": {"$gt": 0}}`,
 			`("players" > $1)`,
-			[]any{float64(0)},
+			[]any{int64(0)},
 			nil,
 		},
 		{
@@ -71,7 +82,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"age": {"$gte": 18}, "name": "John"}`,
 			`(("age" >= $1) AND ("name" = $2))`,
-			[]any{float64(18), "John"},
+			[]any{int64(18), "John"},
 			nil,
 		},
 		{
@@ -95,7 +106,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"b": 1, "c": 2, "a": 3}`,
 			`(("a" = $1) AND ("b" = $2) AND ("c" = $3))`,
-			[]any{float64(3), float64(1), float64(2)},
+			[]any{int64(3), int64(1), int64(2)},
 			nil,
 		},
 		{
@@ -151,7 +162,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"$or": [{"org": "poki", "admin": true}, {"age": {"$gte": 18}}]}`,
 			`((("admin" = $1) AND ("org" = $2)) OR ("age" >= $3))`,
-			[]any{true, "poki", float64(18)},
+			[]any{true, "poki", int64(18)},
 			nil,
 		},
 		{
@@ -183,7 +194,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"$and": [{"name": "John"}, {"version": 3}]}`,
 			`(("name" = $1) AND ("version" = $2))`,
-			[]any{"John", float64(3)},
+			[]any{"John", int64(3)},
 			nil,
 		},
 		{
@@ -191,7 +202,7 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			`{"$and": [{"name": "John", "version": 3}]}`,
 			`(("name" = $1) AND ("version" = $2))`,
-			[]any{"John", float64(3)},
+			[]any{"John", int64(3)},
 			nil,
 		},
 		{
@@ -266,12 +277,52 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 		},
 		{
-			"$not in the wrong place",
+			"$not on field",
+			nil,
+			`{"name": {"$not": {"$regex": "^bot"}}}`,
+			`(NOT COALESCE(("name" ~* $1), FALSE))`,
+			[]any{"^bot"},
+			nil,
+		},
+		{
+			"$not on field with other operators",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"level": {"$gt": 1, "$not": {"$gte": 10, "$ne": 15}}}`,
+			`((("meta"->>'level')::numeric > $1) AND (NOT COALESCE(((("meta"->>'level')::numeric >= $2) AND (("meta"->>'level')::numeric != $3)), FALSE)))`,
+			[]any{int64(1), int64(10), int64(15)},
+			nil,
+		},
+		{
+			"$not on field with regular expression",
+			nil,
+			`{"name": {"$not": "/^bot/i"}, "email": {"$not": "/@example\\.com$/"}}`,
+			`((NOT COALESCE(("email" ~ $1), FALSE)) AND (NOT COALESCE(("name" ~* $2), FALSE)))`,
+			[]any{`@example\.com$`, "^bot"},
+			nil,
+		},
+		{
+			"$not on field with invalid regular expression",
+			nil,
+			`{"name": {"$not": "^bot"}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid regular expression (must be /pattern/flags): ^bot"),
+		},
+		{
+			"$not on field with unsupported regular expression flag",
+			nil,
+			`{"name": {"$not": "/^bot/m"}}`,
+			``,
+			nil,
+			fmt.Errorf("unsupported regular expression flag: m"),
+		},
+		{
+			"$not on field with embedded document",
 			nil,
-			`{"name": {"$not": {"$eq": "John"}}}`,
+			`{"name": {"$not": {"first": "John"}}}`,
 			``,
 			nil,
-			fmt.Errorf("$not as scalar operator not supported"),
+			fmt.Errorf("invalid value for $not operator (must be object with operators or a regular expression): map[first:John]"),
 		},
 		{
 			"$not with a scalar",
@@ -283,11 +334,67 @@ func TestConverter_Convert(t *testing.T) {
 		},
 		{
 			"compare with array",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithArrayDriver(testArrayDriver)},
+			`{"items": [200, 300]}`,
+			`("items" = $1)`,
+			[]any{testArray{[]any{int64(200), int64(300)}}},
+			nil,
+		},
+		{
+			"compare with array without the array driver",
 			nil,
 			`{"items": [200, 300]}`,
 			``,
 			nil,
-			fmt.Errorf("invalid comparison value (must be a primitive): [200 300]"),
+			fmt.Errorf("invalid comparison value (arrays can only be compared with columns when WithArrayDriver is set): [200 300]"),
+		},
+		{
+			"compare with array using the array driver",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithArrayDriver(testArrayDriver)},
+			`{"items": {"$ne": ["a", "b"]}}`,
+			`("items" != $1)`,
+			[]any{testArray{[]any{"a", "b"}}},
+			nil,
+		},
+		{
+			"compare with nested array",
+			nil,
+			`{"items": [[200], [300]]}`,
+			``,
+			nil,
+			fmt.Errorf("invalid comparison value (must be a primitive or an array of primitives): [[200] [300]]"),
+		},
+		{
+			"compare jsonb field with array",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"keys": [1, 3.50, {"$numberLong": "9223372036854775807"}]}`,
+			`("meta"->'keys' = $1::jsonb)`,
+			[]any{`[1,3.50,9223372036854775807]`},
+			nil,
+		},
+		{
+			"compare jsonb field with embedded document",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"position": {"x": 1, "y": [2]}, "size": {"$ne": {"w": "10"}}}`,
+			`(("meta"->'position' = $1::jsonb) AND ("meta"->'size' != $2::jsonb))`,
+			[]any{`{"x":1,"y":[2]}`, `{"w":"10"}`},
+			nil,
+		},
+		{
+			"compare jsonb array elements with array",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"matrix": {"$elemMatch": {"$eq": [1, 2]}}}`,
+			`EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'matrix') AS __filter_placeholder WHERE ("__filter_placeholder" = $1::jsonb))`,
+			[]any{`[1,2]`},
+			nil,
+		},
+		{
+			"compare with embedded document using an operator",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"position": {"$gt": {"x": 1}}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid comparison value (must be a primitive): map[x:1]"),
 		},
 		{
 			"null nornal column",
@@ -301,8 +408,8 @@ func TestConverter_Convert(t *testing.T) {
 			"null jsonb column",
 			[]filter.Option{filter.WithNestedJSONB("meta")},
 			`{"name": null}`,
-			`(jsonb_path_match(meta, 'exists($.name)') AND "meta"->>'name' IS NULL)`,
-			nil,
+			`(jsonb_path_exists("meta", $1::jsonpath) AND "meta"->>'name' IS NULL)`,
+			[]any{`$."name"`},
 			nil,
 		},
 		{
@@ -313,20 +420,44 @@ func TestConverter_Convert(t *testing.T) {
 			nil,
 			fmt.Errorf("$exists operator not supported on non-nested jsonb columns"),
 		},
+		{
+			"$exists on known column",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name", "level"), filter.WithKnownColumns("name", "meta")},
+			`{"name": {"$exists": true}, "level": {"$exists": true}, "pet": {"$exists": false}}`,
+			`(FALSE AND TRUE AND (NOT jsonb_path_exists("meta", $1::jsonpath)))`,
+			[]any{`$."pet"`},
+			nil,
+		},
+		{
+			"$exists on known column with not null policy",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithKnownColumns("name", "level"), filter.WithExistsPolicy(filter.ExistsNotNull)},
+			`{"name": {"$exists": true}, "level": {"$exists": false}, "pet": {"$exists": false}}`,
+			`(("level" IS NULL) AND ("name" IS NOT NULL) AND TRUE)`,
+			nil,
+			nil,
+		},
 		{
 			"not $exists jsonb column",
 			[]filter.Option{filter.WithNestedJSONB("meta")},
 			`{"name": {"$exists": false}}`,
-			`(NOT jsonb_path_match(meta, 'exists($.name)'))`,
-			nil,
+			`(NOT jsonb_path_exists("meta", $1::jsonpath))`,
+			[]any{`$."name"`},
 			nil,
 		},
 		{
 			"$exists jsonb column",
 			[]filter.Option{filter.WithNestedJSONB("meta")},
 			`{"name": {"$exists": true}}`,
-			`(jsonb_path_match(meta, 'exists($.name)'))`,
+			`(jsonb_path_exists("meta", $1::jsonpath))`,
+			[]any{`$."name"`},
 			nil,
+		},
+		{
+			"$exists with numbers",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name"), filter.WithKnownColumns("name", "meta")},
+			`{"name": {"$exists": 0}, "level": {"$exists": 0.0}, "pet": {"$exists": -1}}`,
+			`((NOT jsonb_path_exists("meta", $1::jsonpath)) AND FALSE AND (jsonb_path_exists("meta", $2::jsonpath)))`,
+			[]any{`$."level"`, `$."pet"`},
 			nil,
 		},
 		{
@@ -358,7 +489,7 @@ func TestConverter_Convert(t *testing.T) {
 			[]filter.Option{filter.WithAllowAllColumns(), filter.WithPlaceholderName("__placeholder")},
 			`{"age": {"$elemMatch": {"$gt": 18}}}`,
 			`EXISTS (SELECT 1 FROM unnest("age") AS __placeholder WHERE ("__placeholder"::text > $1))`,
-			[]any{float64(18)},
+			[]any{int64(18)},
 			nil,
 		},
 		{
@@ -366,7 +497,7 @@ func TestConverter_Convert(t *testing.T) {
 			[]filter.Option{filter.WithNestedJSONB("meta")},
 			`{"foo": {"$gt": 0}}`,
 			`(("meta"->>'foo')::numeric > $1)`,
-			[]any{float64(0)},
+			[]any{int64(0)},
 			nil,
 		},
 		{
@@ -377,10 +508,18 @@ func TestConverter_Convert(t *testing.T) {
 			[]any{nil},
 			nil,
 		},
+		{
+			"compare with array using $eq",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithArrayDriver(testArrayDriver)},
+			`{"name": {"$eq": [1, 2]}}`,
+			`("name" = $1)`,
+			[]any{testArray{[]any{int64(1), int64(2)}}},
+			nil,
+		},
 		{
 			"compare with non scalar",
 			nil,
-			`{"name": {"$eq": [1, 2]}}`,
+			`{"name": {"$gt": [1, 2]}}`,
 			``,
 			nil,
 			fmt.Errorf("invalid comparison value (must be a primitive): [1 2]"),
@@ -420,17 +559,25 @@ func TestConverter_Convert(t *testing.T) {
 		{
 			"compare with invalid object",
 			nil,
+			`{"name": {"$eq": {"$field": "foo", "bar": 1}}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $eq operator (must be object with $field key only): map[$field:foo bar:1]"),
+		},
+		{
+			"compare normal column with embedded document",
+			nil,
 			`{"name": {"$eq": {"foo": "bar"}}}`,
 			``,
 			nil,
-			fmt.Errorf("invalid value for $eq operator (must be object with $field key only): map[foo:bar]"),
+			fmt.Errorf("invalid comparison value (embedded documents can only be compared with nested jsonb fields): map[foo:bar]"),
 		},
 		{
 			"numeric comparison with nested jsonb",
 			[]filter.Option{filter.WithNestedJSONB("meta")},
 			`{"foo": 12}`,
 			`(("meta"->>'foo')::numeric = $1)`,
-			[]any{float64(12)},
+			[]any{int64(12)},
 			nil,
 		},
 		{
@@ -438,337 +585,2623 @@ func TestConverter_Convert(t *testing.T) {
 			[]filter.Option{filter.WithNestedJSONB("meta")},
 			`{"foo": { "$eq": 12 }}`,
 			`(("meta"->>'foo')::numeric = $1)`,
-			[]any{float64(12)},
+			[]any{int64(12)},
 			nil,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.option == nil {
-				tt.option = []filter.Option{filter.WithAllowAllColumns()}
-			}
-			c, err := filter.NewConverter(tt.option...)
-			if err != nil {
-				t.Fatal(err)
-			}
-			conditions, values, err := c.Convert([]byte(tt.input), 1)
-			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
-				t.Errorf("Converter.Convert() error = %v, wantErr %v", err, tt.err)
-				return
-			}
-			if err == nil && tt.err != nil {
-				t.Errorf("Converter.Convert() error = nil, wantErr %v", tt.err)
-				return
-			}
-			if conditions != tt.conditions {
-				t.Errorf("Converter.Convert() conditions:\n%v\nwant:\n%v", conditions, tt.conditions)
-			}
-			if !reflect.DeepEqual(values, tt.values) {
-				t.Errorf("Converter.Convert() values:\n%#v\nwant:\n%#v", values, tt.values)
-			}
-		})
-	}
-}
-
-func TestConverter_Convert_startAtParameterIndex(t *testing.T) {
-	c, _ := filter.NewConverter(filter.WithAllowAllColumns())
-	conditions, values, err := c.Convert([]byte(`{"name": "John", "password": "secret"}`), 10)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if want := `(("name" = $10) AND ("password" = $11))`; conditions != want {
-		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
-	}
-	if !reflect.DeepEqual(values, []any{"John", "secret"}) {
-		t.Errorf("Converter.Convert() values = %v, want %v", values, []any{"John"})
-	}
-
-	_, _, err = c.Convert([]byte(`{"name": "John"}`), 0)
-	if want := "startAtParameterIndex must be greater than 0"; err == nil || err.Error() != want {
-		t.Errorf("Converter.Convert(..., 0) error = nil, wantErr %q", want)
-	}
-
-	_, _, err = c.Convert([]byte(`{"name": "John"}`), -123)
-	if want := "startAtParameterIndex must be greater than 0"; err == nil || err.Error() != want {
-		t.Errorf("Converter.Convert(..., -123) error = nil, wantErr %q", want)
-	}
-
-	_, _, err = c.Convert([]byte(`{"name": "John"}`), 1234551231231231231)
-	if err != nil {
-		t.Errorf("Converter.Convert(..., 1234551231231231231) error = %v, want nil", err)
-	}
-}
-
-func TestConverter_WithEmptyCondition(t *testing.T) {
-	c, _ := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithEmptyCondition("TRUE"))
-	conditions, values, err := c.Convert([]byte(`{}`), 1)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if want := "TRUE"; conditions != want {
-		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
-	}
-	if len(values) != 0 {
-		t.Errorf("Converter.Convert() values = %v, want nil", values)
-	}
-}
-
-func TestConverter_NoConstructor(t *testing.T) {
-	c := &filter.Converter{}
-
-	conditions, values, err := c.Convert([]byte(``), 1)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if want := "FALSE"; conditions != want {
-		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
-	}
-	if len(values) != 0 {
-		t.Errorf("Converter.Convert() values = %v, want nil", values)
-	}
-
-	_, _, err = c.Convert([]byte(`{"name": "John"}`), 1)
-	if err == nil {
-		t.Fatal("expected error, got nil")
-	}
-}
-
-func TestConverter_CopyReference(t *testing.T) {
-	c := filter.Converter{}
-	conditions, _, err := c.Convert([]byte(``), 1)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if want := "FALSE"; conditions != want {
-		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
-	}
-}
-
-func TestConverter_RequireAccessControl(t *testing.T) {
-	if _, err := filter.NewConverter(); err != filter.ErrNoAccessOption {
-		t.Errorf("NewConverter() error = %v, want %v", err, filter.ErrNoAccessOption)
-	}
-	if _, err := filter.NewConverter(filter.WithPlaceholderName("___test___")); err != filter.ErrNoAccessOption {
-		t.Errorf("NewConverter() error = %v, want %v", err, filter.ErrNoAccessOption)
-	}
-	if _, err := filter.NewConverter(filter.WithAllowAllColumns()); err != nil {
-		t.Errorf("NewConverter() error = %v, want no error", err)
-	}
-	if _, err := filter.NewConverter(filter.WithAllowColumns("name", "map")); err != nil {
-		t.Errorf("NewConverter() error = %v, want no error", err)
-	}
-	if _, err := filter.NewConverter(filter.WithAllowColumns()); err != nil {
-		t.Errorf("NewConverter() error = %v, want no error", err)
-	}
-	if _, err := filter.NewConverter(filter.WithNestedJSONB("meta", "created_at", "updated_at")); err != nil {
-		t.Errorf("NewConverter() error = %v, want no error", err)
-	}
-	if _, err := filter.NewConverter(filter.WithDisallowColumns("password")); err != nil {
-		t.Errorf("NewConverter() error = %v, want no error", err)
-	}
-}
-
-func TestConverter_AccessControl(t *testing.T) {
-	f := func(in string, wantErr error, options ...filter.Option) func(t *testing.T) {
-		t.Helper()
-		return func(t *testing.T) {
-			t.Helper()
-			c := &filter.Converter{}
-			if options != nil {
-				c, _ = filter.NewConverter(options...)
-				// requirement of access control is tested above.
-			}
-			q, _, err := c.Convert([]byte(in), 1)
-			t.Log(in, "->", q, err)
-			if wantErr == nil && err != nil {
-				t.Fatalf("no error returned, expected error: %v", err)
-			} else if wantErr != nil && err == nil {
-				t.Fatalf("expected error: %v", wantErr)
-			} else if wantErr != nil && wantErr.Error() != err.Error() {
-				t.Fatalf("error mismatch: %v != %v", err, wantErr)
-			}
-		}
-	}
-
-	no := func(c string) error { return filter.ColumnNotAllowedError{Column: c} }
-
-	t.Run("allow all, single root field",
-		f(`{"name":"John"}`, nil, filter.WithAllowAllColumns()))
-
-	t.Run("allow name, single allowed root field",
-		f(`{"name":"John"}`, nil, filter.WithAllowColumns("name")))
-
-	t.Run("allow name, single disallowed root field",
-		f(`{"password":"hacks"}`, no("password"), filter.WithAllowColumns("name")))
-
-	t.Run("allowed meta, single allowed nested field",
-		f(`{"map":"de_dust"}`, nil, filter.WithNestedJSONB("meta", "created_at")))
-
-	t.Run("allowed nested excemption, single allowed field",
-		f(`{"created_at":"de_dust"}`, nil, filter.WithNestedJSONB("meta", "created_at")))
-
-	t.Run("multi allow, single allowed root field",
-		f(`{"name":"John"}`, nil, filter.WithAllowColumns("name", "email")))
-
-	t.Run("multi allow, two allowed root fields",
-		f(`{"name":"John", "email":"test@example.org"}`, nil, filter.WithAllowColumns("name", "email")))
-
-	t.Run("multi allow, mixes access",
-		f(`{"name":"John", "password":"hacks"}`, no("password"), filter.WithAllowColumns("name", "email")))
-
-	t.Run("multi allow, mixes access",
-		f(`{"name":"John", "password":"hacks"}`, no("password"), filter.WithAllowColumns("name", "email")))
-
-	t.Run("allowed basic $and",
-		f(`{"$and": [{"name": "John"}, {"version": 3}]}`, nil, filter.WithAllowColumns("name", "version")))
+		{
+			"integer above 2^53",
+			nil,
+			`{"id": 9007199254740993}`,
+			`("id" = $1)`,
+			[]any{int64(9007199254740993)},
+			nil,
+		},
+		{
+			"integer above 2^53 with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"id": {"$gt": 9007199254740993}}`,
+			`(("meta"->>'id')::numeric > $1)`,
+			[]any{int64(9007199254740993)},
+			nil,
+		},
+		{
+			"integer above int64",
+			nil,
+			`{"id": 92233720368547758070}`,
+			`("id" = $1)`,
+			[]any{filter.Decimal("92233720368547758070")},
+			nil,
+		},
+		{
+			"decimal value",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"price": 0.1}`,
+			`(("meta"->>'price')::numeric = $1)`,
+			[]any{filter.Decimal("0.1")},
+			nil,
+		},
+		{
+			"$in with integers above 2^53",
+			nil,
+			`{"id": {"$in": [9007199254740993, 9007199254740995, 1.5]}}`,
+			`("id" = ANY($1))`,
+			[]any{[]any{int64(9007199254740993), int64(9007199254740995), filter.Decimal("1.5")}},
+			nil,
+		},
+		{
+			"extended json $date",
+			nil,
+			`{"created_at": {"$gte": {"$date": "2024-01-01T00:00:00Z"}}}`,
+			`("created_at" >= $1)`,
+			[]any{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"extended json $date with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"created_at": {"$gte": {"$date": "2024-01-01T00:00:00Z"}}}`,
+			`(` + timestamptz(`"meta"->>'created_at'`) + ` >= $1)`,
+			[]any{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"extended json canonical $date",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"created_at": {"$date": {"$numberLong": "1704067200000"}}}`,
+			`(` + timestamptz(`"meta"->>'created_at'`) + ` = $1)`,
+			[]any{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"extended json $numberLong",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"id": {"$numberLong": "9007199254740993"}}`,
+			`(("meta"->>'id')::numeric = $1)`,
+			[]any{int64(9007199254740993)},
+			nil,
+		},
+		{
+			"extended json $numberDecimal",
+			nil,
+			`{"price": {"$lt": {"$numberDecimal": "19.99"}}}`,
+			`("price" < $1)`,
+			[]any{filter.Decimal("19.99")},
+			nil,
+		},
+		{
+			"extended json $oid",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"owner": {"$oid": "5F3C1B2A9D8E7F6A5B4C3D2E"}}`,
+			`("meta"->>'owner' = $1)`,
+			[]any{"5f3c1b2a9d8e7f6a5b4c3d2e"},
+			nil,
+		},
+		{
+			"extended json $uuid",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"session": {"$ne": {"$uuid": "123E4567-E89B-12D3-A456-426614174000"}}}`,
+			`(("meta"->>'session')::uuid != $1)`,
+			[]any{filter.UUID("123e4567-e89b-12d3-a456-426614174000")},
+			nil,
+		},
+		{
+			"extended json $binary uuid",
+			nil,
+			`{"session": {"$binary": {"base64": "Ej5FZ+ibEtOkVkJmFBdAAA==", "subType": "04"}}}`,
+			`("session" = $1)`,
+			[]any{filter.UUID("123e4567-e89b-12d3-a456-426614174000")},
+			nil,
+		},
+		{
+			"extended json in $in",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"session": {"$in": [{"$uuid": "123e4567-e89b-12d3-a456-426614174000"}, null]}}`,
+			`(("meta"->>'session')::uuid = ANY($1))`,
+			[]any{[]any{filter.UUID("123e4567-e89b-12d3-a456-426614174000"), nil}},
+			nil,
+		},
+		{
+			"extended json invalid $date",
+			nil,
+			`{"created_at": {"$date": "yesterday"}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $date (must be an ISO-8601 date): yesterday"),
+		},
+		{
+			"extended json invalid $oid",
+			nil,
+			`{"owner": {"$oid": "nope"}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $oid (must be 24 hex characters): nope"),
+		},
+		{
+			"declared timestamp field",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp})},
+			`{"last_seen": {"$gt": "2024-05-01T00:00:00Z", "$lt": "2024-06-01"}}`,
+			`((` + timestamptz(`"meta"->>'last_seen'`) + ` > $1) AND (` + timestamptz(`"meta"->>'last_seen'`) + ` < $2))`,
+			[]any{time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"declared timestamp field equality",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp})},
+			`{"last_seen": "2024-05-01T12:00:00+02:00"}`,
+			`(` + timestamptz(`"meta"->>'last_seen'`) + ` = $1)`,
+			[]any{time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("", 2*60*60))},
+			nil,
+		},
+		{
+			"declared timestamp field $in",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp})},
+			`{"last_seen": {"$in": ["2024-05-01", "2024-05-02"]}}`,
+			`(` + timestamptz(`"meta"->>'last_seen'`) + ` = ANY($1))`,
+			[]any{[]any{time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)}},
+			nil,
+		},
+		{
+			"declared timestamp field $regex",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp})},
+			`{"last_seen": {"$regex": "^2024"}}`,
+			`("meta"->>'last_seen' ~* $1)`,
+			[]any{"^2024"},
+			nil,
+		},
+		{
+			"declared timestamp fields compared",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp, "created_at": filter.FieldTypeTimestamp})},
+			`{"last_seen": {"$gt": {"$field": "created_at"}}}`,
+			`(` + timestamptz(`"meta"->>'last_seen'`) + ` > ` + timestamptz(`"meta"->>'created_at'`) + `)`,
+			nil,
+			nil,
+		},
+		{
+			"declared timestamp field with invalid value",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp})},
+			`{"last_seen": {"$gt": "last week"}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid timestamp value for last_seen: last week"),
+		},
+		{
+			"boolean with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta", "active")},
+			`{"verified": true, "active": true}`,
+			`(("active" = $1) AND ((CASE WHEN jsonb_typeof("meta"->'verified') = 'boolean' THEN ("meta"->>'verified')::boolean END) = $2))`,
+			[]any{true, true},
+			nil,
+		},
+		{
+			"boolean $ne with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"verified": {"$ne": false}}`,
+			`((CASE WHEN jsonb_typeof("meta"->'verified') = 'boolean' THEN ("meta"->>'verified')::boolean END) != $1)`,
+			[]any{false},
+			nil,
+		},
+		{
+			"boolean $in with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"verified": {"$in": [true, null]}}`,
+			`((CASE WHEN jsonb_typeof("meta"->'verified') = 'boolean' THEN ("meta"->>'verified')::boolean END) = ANY($1))`,
+			[]any{[]any{true, nil}},
+			nil,
+		},
+		{
+			"boolean $elemMatch with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"flags": {"$elemMatch": {"$eq": true}}}`,
+			`EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'flags') AS __filter_placeholder WHERE ((CASE WHEN jsonb_typeof("__filter_placeholder") = 'boolean' THEN ("__filter_placeholder" #>> '{}')::boolean END) = $1))`,
+			[]any{true},
+			nil,
+		},
+		{
+			"timestamp $elemMatch with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			`{"logins": {"$elemMatch": {"$gte": {"$date": "2024-01-01T00:00:00Z"}}}, "bans": {"$elemMatch": {"$in": [{"$date": "2024-01-01T00:00:00Z"}]}}}`,
+			`(EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'bans') AS __filter_placeholder WHERE (` + timestamptz(`"__filter_placeholder" #>> '{}'`) + ` = ANY($1))) AND ` +
+				`EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'logins') AS __filter_placeholder WHERE (` + timestamptz(`"__filter_placeholder" #>> '{}'`) + ` >= $2)))`,
+			[]any{[]any{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"boolean $elemMatch on normal column with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta", "flags")},
+			`{"flags": {"$elemMatch": {"$eq": true}}}`,
+			`EXISTS (SELECT 1 FROM unnest("flags") AS __filter_placeholder WHERE ("__filter_placeholder"::text = $1))`,
+			[]any{true},
+			nil,
+		},
+		{
+			"jsonb containment",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name"), filter.WithJSONBContainment()},
+			`{"pet": "dog", "verified": true, "name": "John"}`,
+			`(("name" = $1) AND ("meta" @> $2::jsonb) AND ("meta" @> $3::jsonb))`,
+			[]any{"John", `{"pet":"dog"}`, `{"verified":true}`},
+			nil,
+		},
+		{
+			"jsonb containment with $eq and $in",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONBContainment()},
+			`{"pet": {"$eq": "dog"}, "class": {"$in": ["mage", "rogue"]}}`,
+			`(("meta" @> $1::jsonb OR "meta" @> $2::jsonb) AND ("meta" @> $3::jsonb))`,
+			[]any{`{"class":"mage"}`, `{"class":"rogue"}`, `{"pet":"dog"}`},
+			nil,
+		},
+		{
+			"jsonb containment falls back",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONBContainment()},
+			`{"level": 10, "pet": null, "class": {"$in": ["mage", null]}, "mount": {"$ne": "horse"}}`,
+			`(("meta"->>'class' = ANY($1)) AND (("meta"->>'level')::numeric = $2) AND ("meta"->>'mount' != $3) AND (jsonb_path_exists("meta", $4::jsonpath) AND "meta"->>'pet' IS NULL))`,
+			[]any{[]any{"mage", nil}, int64(10), "horse", `$."pet"`},
+			nil,
+		},
+		{
+			"jsonb containment with numeric and boolean strings",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONBContainment()},
+			`{"guild_id": "20", "verified": "true", "class": {"$in": ["mage", "1.5"]}}`,
+			`(("meta"->>'class' = ANY($1)) AND ("meta"->>'guild_id' = $2) AND ("meta"->>'verified' = $3))`,
+			[]any{[]any{"mage", "1.5"}, "20", "true"},
+			nil,
+		},
+		{
+			"jsonb containment not used in $elemMatch",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONBContainment()},
+			`{"hats": {"$elemMatch": {"$eq": "cap"}}}`,
+			`EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder"::text = $1))`,
+			[]any{"cap"},
+			nil,
+		},
+		{
+			"jsonpath",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"pet": "dog", "level": {"$gt": 10, "$lte": 0.5}}`,
+			`jsonb_path_exists("meta", $1::jsonpath, $2::jsonb)`,
+			[]any{`$ ? ((@."level" > $v1 && @."level" <= $v2) && @."pet" == $v3)`, `{"v1":10,"v2":0.5,"v3":"dog"}`},
+			nil,
+		},
+		{
+			"jsonpath with exemptions",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name"), filter.WithJSONPath()},
+			`{"name": "John", "pet": {"$exists": true}, "mount": {"$exists": false}}`,
+			`(("name" = $1) AND jsonb_path_exists("meta", $2::jsonpath))`,
+			[]any{"John", `$ ? (!exists(@."mount") && exists(@."pet"))`},
+			nil,
+		},
+		{
+			"jsonpath $exists with numbers",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"pet": {"$exists": 1}, "mount": {"$exists": 0}}`,
+			`jsonb_path_exists("meta", $1::jsonpath)`,
+			[]any{`$ ? (!exists(@."mount") && exists(@."pet"))`},
+			nil,
+		},
+		{
+			"jsonpath logical operators",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"$or": [{"pet": "cat"}, {"$and": [{"pet": null}, {"level": {"$ne": 1}}]}], "$nor": [{"class": {"$in": ["mage", "rogue"]}}], "$not": {"mount": {"$regex": "^dr\"a"}}}`,
+			`jsonb_path_exists("meta", $1::jsonpath, $2::jsonb)`,
+			[]any{`$ ? (!((@."class" == $v1 || @."class" == $v2)) && !(@."mount" like_regex "^dr\"a" flag "i") && (@."pet" == $v3 || ((!exists(@."pet") || @."pet" == null) && @."level" != $v4)))`, `{"v1":"mage","v2":"rogue","v3":"cat","v4":1}`},
+			nil,
+		},
+		{
+			"jsonpath $elemMatch, $nin and $field",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"keys": {"$elemMatch": {"$gt": 4, "$lt": 6}}, "hats": {"$nin": ["cap"]}, "a": {"$lt": {"$field": "b"}}, "c": {"$field": "d"}}`,
+			`jsonb_path_exists("meta", $1::jsonpath, $2::jsonb)`,
+			[]any{`$ ? (@."a" < @."b" && @."c" == @."d" && !(@."hats" == $v1) && exists(@."keys"[*] ? ((@ > $v2 && @ < $v3))))`, `{"v1":"cap","v2":4,"v3":6}`},
+			nil,
+		},
+		{
+			"jsonpath falls back",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name"), filter.WithJSONPath()},
+			`{"$or": [{"name": "John"}, {"pet": "dog"}], "created_at": {"$gt": {"$date": "2024-01-01T00:00:00Z"}}}`,
+			`((("name" = $1) OR jsonb_path_exists("meta", $2::jsonpath, $3::jsonb)) AND (` + timestamptz(`"meta"->>'created_at'`) + ` > $4))`,
+			[]any{"John", `$ ? (@."pet" == $v1)`, `{"v1":"dog"}`, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"jsonpath with array value",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"pet": {"$eq": [1, 2]}, "level": 1}`,
+			`(("meta"->'pet' = $1::jsonb) AND jsonb_path_exists("meta", $2::jsonpath, $3::jsonb))`,
+			[]any{`[1,2]`, `$ ? (@."level" == $v1)`, `{"v1":1}`},
+			nil,
+		},
+		{
+			"jsonpath with invalid value",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"pet": {"$gt": [1, 2]}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid comparison value (must be a primitive): [1 2]"),
+		},
+		{
+			"implicit array matching",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name"), filter.WithImplicitArrayMatching()},
+			`{"hats": "cap", "name": "John"}`,
+			`((CASE WHEN jsonb_typeof("meta"->'hats') = 'array' THEN EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder" #>> '{}' = $1)) ELSE ("meta"->>'hats' = $1) END) AND ("name" = $2))`,
+			[]any{"cap", "John"},
+			nil,
+		},
+		{
+			"implicit array matching with $ne and $gt",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithImplicitArrayMatching()},
+			`{"hats": {"$ne": "cap"}, "keys": {"$gt": 4}}`,
+			`((CASE WHEN jsonb_typeof("meta"->'hats') = 'array' THEN NOT EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder" #>> '{}' = $1)) ELSE NOT ("meta"->>'hats' = $1) END) AND (CASE WHEN jsonb_typeof("meta"->'keys') = 'array' THEN EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'keys') AS __filter_placeholder WHERE (("__filter_placeholder" #>> '{}')::numeric > $2)) ELSE (("meta"->>'keys')::numeric > $2) END))`,
+			[]any{"cap", int64(4)},
+			nil,
+		},
+		{
+			"implicit array matching with $in and $nin",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithImplicitArrayMatching()},
+			`{"hats": {"$nin": ["cap"]}, "flags": {"$in": [true]}}`,
+			`((CASE WHEN jsonb_typeof("meta"->'flags') = 'array' THEN EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'flags') AS __filter_placeholder WHERE ((CASE WHEN jsonb_typeof("__filter_placeholder") = 'boolean' THEN ("__filter_placeholder" #>> '{}')::boolean END) = ANY($1))) ELSE ((CASE WHEN jsonb_typeof("meta"->'flags') = 'boolean' THEN ("meta"->>'flags')::boolean END) = ANY($1)) END) AND (CASE WHEN jsonb_typeof("meta"->'hats') = 'array' THEN NOT EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder" #>> '{}' = ANY($2))) ELSE NOT ("meta"->>'hats' = ANY($2)) END))`,
+			[]any{[]any{true}, []any{"cap"}},
+			nil,
+		},
+		{
+			"implicit array matching with jsonb containment",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithImplicitArrayMatching(), filter.WithJSONBContainment()},
+			`{"hats": "cap"}`,
+			`("meta" @> $1::jsonb OR "meta" @> $2::jsonb)`,
+			[]any{`{"hats":"cap"}`, `{"hats":["cap"]}`},
+			nil,
+		},
+		{
+			"implicit array matching not used for $elemMatch and null",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithImplicitArrayMatching()},
+			`{"hats": {"$elemMatch": {"$eq": "cap"}}, "pet": null}`,
+			`(EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder"::text = $1)) AND (jsonb_path_exists("meta", $2::jsonpath) AND "meta"->>'pet' IS NULL))`,
+			[]any{"cap", `$."pet"`},
+			nil,
+		},
+		{
+			"mongo null semantics",
+			[]filter.Option{filter.WithNestedJSONB("meta", "mount"), filter.WithMongoNullSemantics()},
+			`{"mount": {"$ne": "horse"}, "pet": {"$nin": ["cat", "dog"]}, "level": {"$ne": 1}, "class": null, "guild": {"$eq": null}}`,
+			`(("meta"->>'class' IS NULL) AND ("meta"->>'guild' IS NOT DISTINCT FROM $1) AND (("meta"->>'level')::numeric IS DISTINCT FROM $2) AND ("mount" IS DISTINCT FROM $3) AND (NOT COALESCE("meta"->>'pet' = ANY($4), FALSE)))`,
+			[]any{nil, int64(1), "horse", []any{"cat", "dog"}},
+			nil,
+		},
+		{
+			"mongo null semantics with null in $in and $nin",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithMongoNullSemantics()},
+			`{"mount": {"$in": ["horse", null]}, "pet": {"$nin": ["cat", null]}}`,
+			`(("mount" = ANY($1) OR "mount" IS NULL) AND (NOT COALESCE("pet" = ANY($2) OR "pet" IS NULL, FALSE)))`,
+			[]any{[]any{"horse", nil}, []any{"cat", nil}},
+			nil,
+		},
+		{
+			"mongo null semantics with $nor and $field",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithMongoNullSemantics()},
+			`{"$nor": [{"mount": "horse"}, {"level": {"$gt": 10}}], "a": {"$ne": {"$field": "b"}}}`,
+			`(NOT COALESCE((("mount" = $1) OR ("level" > $2)), FALSE) AND ("a" IS DISTINCT FROM "b"))`,
+			[]any{"horse", int64(10)},
+			nil,
+		},
+		{
+			"mongo null semantics with implicit array matching",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithMongoNullSemantics(), filter.WithImplicitArrayMatching()},
+			`{"hats": {"$ne": "cap"}}`,
+			`(CASE WHEN jsonb_typeof("meta"->'hats') = 'array' THEN NOT EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'hats') AS __filter_placeholder WHERE ("__filter_placeholder" #>> '{}' = $1)) ELSE NOT COALESCE(("meta"->>'hats' = $1), FALSE) END)`,
+			[]any{"cap"},
+			nil,
+		},
+		{
+			"mongo null semantics with jsonpath",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithMongoNullSemantics(), filter.WithJSONPath()},
+			`{"mount": {"$ne": "horse"}}`,
+			`jsonb_path_exists("meta", $1::jsonpath, $2::jsonb)`,
+			[]any{`$ ? (!(@."mount" == $v1))`, `{"v1":"horse"}`},
+			nil,
+		},
+		{
+			"jsonpath $not on field",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath()},
+			`{"name": {"$not": "/^bot/"}, "level": {"$not": {"$gt": 10}}}`,
+			`jsonb_path_exists("meta", $1::jsonpath, $2::jsonb)`,
+			[]any{`$ ? (!(@."level" > $v1) && !(@."name" like_regex "^bot"))`, `{"v1":10}`},
+			nil,
+		},
+		{
+			"$text",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithTextSearch("title")},
+			`{"$text": {"$search": "dragon rider"}, "level": {"$gt": 10}}`,
+			`((to_tsvector('english', "title") @@ websearch_to_tsquery('english', $1)) AND ("level" > $2))`,
+			[]any{"dragon rider", int64(10)},
+			nil,
+		},
+		{
+			"$text with multiple columns and $language",
+			[]filter.Option{filter.WithNestedJSONB("meta", "title"), filter.WithTextSearch("title", "description")},
+			`{"$text": {"$search": "draak", "$language": "Dutch", "$caseSensitive": false}}`,
+			`(to_tsvector('dutch', coalesce("title", '') || ' ' || coalesce("meta"->>'description', '')) @@ websearch_to_tsquery('dutch', $1))`,
+			[]any{"draak"},
+			nil,
+		},
+		{
+			"$text with tsvector column",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithTextSearchVector("search")},
+			`{"$or": [{"$text": {"$search": "dragon", "$language": "none"}}, {"level": 1}]}`,
+			`(("search" @@ websearch_to_tsquery('simple', $1)) OR ("level" = $2))`,
+			[]any{"dragon", int64(1)},
+			nil,
+		},
+		{
+			"$text without text search columns",
+			nil,
+			`{"$text": {"$search": "dragon"}}`,
+			``,
+			nil,
+			fmt.Errorf("$text operator not supported (no text search columns configured)"),
+		},
+		{
+			"$text with invalid language",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithTextSearch("title")},
+			`{"$text": {"$search": "dragon", "$language": "english'); --"}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $language: english'); --"),
+		},
+		{
+			"$text with case sensitive search",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithTextSearch("title")},
+			`{"$text": {"$search": "dragon", "$caseSensitive": true}}`,
+			``,
+			nil,
+			fmt.Errorf("$caseSensitive not supported"),
+		},
+		{
+			"$near with $geometry",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$near": {"$geometry": {"type": "Point", "coordinates": [4.9, 52.4]}, "$maxDistance": 1000}}}`,
+			`(ST_DWithin("location", ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $3))`,
+			[]any{4.9, 52.4, float64(1000)},
+			nil,
+		},
+		{
+			"$near with legacy coordinates on geometry column",
+			[]filter.Option{filter.WithNestedJSONB("meta", "area"), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"area": {"$near": [4.9, 52.4], "$maxDistance": 1000, "$minDistance": 10}}`,
+			`(ST_DWithin("area"::geography, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $3) AND ST_Distance("area"::geography, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography) >= $4)`,
+			[]any{4.9, 52.4, float64(1000), float64(10)},
+			nil,
+		},
+		{
+			"$near on point column",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"pos": {"$near": [1, 2], "$maxDistance": 5}, "level": 1}`,
+			`(("level" = $1) AND (("pos" <-> point($2, $3)) <= $4))`,
+			[]any{int64(1), float64(1), float64(2), float64(5)},
+			nil,
+		},
+		{
+			"$nearSphere without distance",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$nearSphere": {"type": "Point", "coordinates": [4.9, 52.4]}}}`,
+			`("location" IS NOT NULL)`,
+			nil,
+			nil,
+		},
+		{
+			"$geoWithin $box and $polygon",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"area": {"$geoWithin": {"$box": [[0, 0], [10, 10]]}}, "pos": {"$geoWithin": {"$polygon": [[0, 0], [3, 6], [6, 0]]}}}`,
+			`((ST_CoveredBy("area", ST_MakeEnvelope($1, $2, $3, $4, 4326))) AND ("pos" <@ $5::polygon))`,
+			[]any{float64(0), float64(0), float64(10), float64(10), "((0,0),(3,6),(6,0))"},
+			nil,
+		},
+		{
+			"$geoWithin $polygon on geography column",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$geoWithin": {"$polygon": [[0, 0], [3, 6], [6, 0]]}}}`,
+			`(ST_CoveredBy("location", ST_GeomFromGeoJSON($1)::geography))`,
+			[]any{`{"coordinates":[[[0,0],[3,6],[6,0],[0,0]]],"type":"Polygon"}`},
+			nil,
+		},
+		{
+			"$geoWithin $centerSphere and $center",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$geoWithin": {"$centerSphere": [[4.9, 52.4], 0.5]}}, "pos": {"$geoWithin": {"$center": [[1, 2], 3]}}}`,
+			`((ST_DWithin("location", ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $3)) AND ("pos" <@ circle(point($4, $5), $6)))`,
+			[]any{4.9, 52.4, float64(3189050), float64(1), float64(2), float64(3)},
+			nil,
+		},
+		{
+			"$geoWithin and $geoIntersects with $geometry",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"area": {"$geoIntersects": {"$geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1.5]]}}}, "location": {"$geoWithin": {"$geometry": {"type": "Polygon", "coordinates": [[[0, 0], [3, 6], [6, 0], [0, 0]]]}}}}`,
+			`((ST_Intersects("area", ST_GeomFromGeoJSON($1))) AND (ST_CoveredBy("location", ST_GeomFromGeoJSON($2)::geography)))`,
+			[]any{`{"coordinates":[[0,0],[1,1.5]],"type":"LineString"}`, `{"coordinates":[[[0,0],[3,6],[6,0],[0,0]]],"type":"Polygon"}`},
+			nil,
+		},
+		{
+			"geo operator on undeclared column",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$near": [4.9, 52.4]}}`,
+			``,
+			nil,
+			fmt.Errorf("$near operator requires a geometry, geography or point column: location"),
+		},
+		{
+			"$maxDistance without $near",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$maxDistance": 10}}`,
+			``,
+			nil,
+			fmt.Errorf("$maxDistance operator requires $near or $nearSphere"),
+		},
+		{
+			"$nearSphere on point column",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"pos": {"$nearSphere": [1, 2]}}`,
+			``,
+			nil,
+			fmt.Errorf("$nearSphere operator not supported on point columns"),
+		},
+		{
+			"$near with invalid point",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$near": [1, "2"]}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $near operator: must be a number: 2"),
+		},
+		{
+			"$expr with arithmetic",
+			nil,
+			`{"$expr": {"$lte": [{"$add": ["$playerCount", 2]}, "$maxPlayers"]}}`,
+			`((("playerCount" + $1::numeric) <= "maxPlayers"))`,
+			[]any{int64(2)},
+			nil,
+		},
+		{
+			"$expr on nested jsonb fields",
+			[]filter.Option{filter.WithNestedJSONB("meta", "level")},
+			`{"$expr": {"$and": [{"$gt": [{"$divide": ["$score", {"$size": "$rounds"}]}, 1.5]}, {"$lt": ["$level", "$maxLevel"]}]}}`,
+			`((((("meta"->>'score')::numeric / NULLIF((CASE WHEN jsonb_typeof("meta"->'rounds') = 'array' THEN jsonb_array_length("meta"->'rounds') END), 0)::numeric) > $1::numeric) AND ("level" < ("meta"->>'maxLevel')::numeric)))`,
+			[]any{filter.Decimal("1.5")},
+			nil,
+		},
+		{
+			"$expr with string operators",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name")},
+			`{"$expr": {"$or": [{"$eq": [{"$toLower": "$name"}, "bob"]}, {"$ne": [{"$concat": ["$first", " ", "$last"]}, "$name"]}, {"$eq": ["$guild", null]}]}}`,
+			`(((lower("name"::text) = $1) OR (("meta"->>'first' || $2::text || "meta"->>'last') != "name") OR ("meta"->>'guild' IS NULL)))`,
+			[]any{"bob", " "},
+			nil,
+		},
+		{
+			"$expr with strings",
+			nil,
+			`{"$expr": {"$or": [{"$eq": ["$status", "open"]}, {"$eq": ["open", "$status"]}, {"$eq": ["a", "b"]}]}}`,
+			`((("status" = $1) OR ($2 = "status") OR ($3::text = $4::text)))`,
+			[]any{"open", "open", "a", "b"},
+			nil,
+		},
+		{
+			"$expr with $subtract, $multiply, $abs and a timestamp",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithMongoNullSemantics(), filter.WithFieldTypes(map[string]filter.FieldType{"created_at": filter.FieldTypeTimestamp})},
+			`{"$expr": {"$and": [{"$gte": [{"$abs": {"$subtract": ["$a", "$b"]}}, {"$multiply": ["$c", 2, 0.5]}]}, {"$ne": ["$created_at", "2024-01-01"]}]}}`,
+			`(((abs(("a" - "b")) >= ("c" * $1::numeric * $2::numeric)) AND ("created_at" IS DISTINCT FROM $3::timestamptz)))`,
+			[]any{int64(2), filter.Decimal("0.5"), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			nil,
+		},
+		{
+			"$expr with disallowed column",
+			[]filter.Option{filter.WithAllowColumns("level")},
+			`{"$expr": {"$gt": ["$level", "$password"]}}`,
+			``,
+			nil,
+			filter.ColumnNotAllowedError{Column: "password"},
+		},
+		{
+			"$expr with unsupported operator",
+			nil,
+			`{"$expr": {"$gt": [{"$function": {"body": "", "args": [], "lang": "js"}}, 1]}}`,
+			``,
+			nil,
+			fmt.Errorf("unsupported $expr operator: $function"),
+		},
+		{
+			"$expr without a comparison",
+			nil,
+			`{"$expr": {"$add": ["$a", 1]}}`,
+			``,
+			nil,
+			fmt.Errorf("unsupported $expr operator (must be a comparison, $and or $or): $add"),
+		},
+		{
+			"$expr with invalid arithmetic operand",
+			nil,
+			`{"$expr": {"$gt": [{"$add": ["$a", "1"]}, 1]}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $add operator (must be numbers): [$a 1]"),
+		},
+		{
+			"trailing data",
+			nil,
+			`{"name": "John"} {}`,
+			``,
+			nil,
+			fmt.Errorf("invalid data after top-level value"),
+		},
+		{
+			"collation",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name", "level"), filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText}), filter.WithDefaultCollation("und-x-icu")},
+			`{"name": "John", "pet": {"$in": ["dog", "cat"]}, "level": {"$gt": 10}, "mount": {"$regex": "^hor"}}`,
+			`(("level" > $1) AND ("meta"->>'mount' COLLATE "und-x-icu" ~* $2) AND ("name" COLLATE "und-x-icu" = $3) AND ("meta"->>'pet' COLLATE "und-x-icu" = ANY($4)))`,
+			[]any{int64(10), "^hor", "John", []any{"dog", "cat"}},
+			nil,
+		},
+		{
+			"collation only on text columns",
+			[]filter.Option{filter.WithNestedJSONB("meta", "created_at", "id", "status"), filter.WithDefaultCollation("und-x-icu")},
+			`{"created_at": {"$gte": "2024-01-01"}, "id": "2a1d5d4e-a8f0-4d3e-9f4b-8b3c1a2d3e4f", "status": {"$in": ["open", "closed"]}, "pet": "dog"}`,
+			`(("created_at" >= $1) AND ("id" = $2) AND ("meta"->>'pet' COLLATE "und-x-icu" = $3) AND ("status" = ANY($4)))`,
+			[]any{"2024-01-01", "2a1d5d4e-a8f0-4d3e-9f4b-8b3c1a2d3e4f", "dog", []any{"open", "closed"}},
+			nil,
+		},
+		{
+			"collation without containment",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONBContainment(), filter.WithDefaultCollation("und-x-icu")},
+			`{"pet": "dog", "active": true}`,
+			`(("meta" @> $1::jsonb) AND ("meta"->>'pet' COLLATE "und-x-icu" = $2))`,
+			[]any{`{"active":true}`, "dog"},
+			nil,
+		},
+		{
+			"collation with JSON path",
+			[]filter.Option{filter.WithNestedJSONB("meta"), filter.WithJSONPath(), filter.WithDefaultCollation("und-x-icu")},
+			`{"pet": "dog", "level": {"$gt": 3}}`,
+			`(("meta"->>'pet' COLLATE "und-x-icu" = $1) AND jsonb_path_exists("meta", $2::jsonpath, $3::jsonb))`,
+			[]any{"dog", `$ ? (@."level" > $v1)`, `{"v1":3}`},
+			nil,
+		},
+		{
+			"collation with $not regex",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText}), filter.WithDefaultCollation("C")},
+			`{"name": {"$not": "/^bot/"}}`,
+			`(NOT COALESCE(("name" COLLATE "C" ~ $1), FALSE))`,
+			[]any{"^bot"},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.option == nil {
+				tt.option = []filter.Option{filter.WithAllowAllColumns()}
+			}
+			c, err := filter.NewConverter(tt.option...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.Convert() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.Convert() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if conditions != tt.conditions {
+				t.Errorf("Converter.Convert() conditions:\n%v\nwant:\n%v", conditions, tt.conditions)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("Converter.Convert() values:\n%#v\nwant:\n%#v", values, tt.values)
+			}
+		})
+	}
+}
+
+func TestConverter_Convert_startAtParameterIndex(t *testing.T) {
+	c, _ := filter.NewConverter(filter.WithAllowAllColumns())
+	conditions, values, err := c.Convert([]byte(`{"name": "John", "password": "secret"}`), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `(("name" = $10) AND ("password" = $11))`; conditions != want {
+		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
+	}
+	if !reflect.DeepEqual(values, []any{"John", "secret"}) {
+		t.Errorf("Converter.Convert() values = %v, want %v", values, []any{"John"})
+	}
+
+	_, _, err = c.Convert([]byte(`{"name": "John"}`), 0)
+	if want := "startAtParameterIndex must be greater than 0"; err == nil || err.Error() != want {
+		t.Errorf("Converter.Convert(..., 0) error = nil, wantErr %q", want)
+	}
+
+	_, _, err = c.Convert([]byte(`{"name": "John"}`), -123)
+	if want := "startAtParameterIndex must be greater than 0"; err == nil || err.Error() != want {
+		t.Errorf("Converter.Convert(..., -123) error = nil, wantErr %q", want)
+	}
+
+	_, _, err = c.Convert([]byte(`{"name": "John"}`), 1234551231231231231)
+	if err != nil {
+		t.Errorf("Converter.Convert(..., 1234551231231231231) error = %v, want nil", err)
+	}
+}
+
+func TestConverter_ConvertMap(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		option     []filter.Option
+		input      map[string]any
+		conditions string
+		values     []any
+		err        error
+	}{
+		{
+			"int value",
+			nil,
+			map[string]any{"age": 30},
+			`("age" = $1)`,
+			[]any{30},
+			nil,
+		},
+		{
+			"int64 value with nested jsonb",
+			[]filter.Option{filter.WithNestedJSONB("meta")},
+			map[string]any{"level": map[string]any{"$gte": int64(10)}},
+			`(("meta"->>'level')::numeric >= $1)`,
+			[]any{int64(10)},
+			nil,
+		},
+		{
+			"time value",
+			nil,
+			map[string]any{"created_at": map[string]any{"$gte": created}},
+			`("created_at" >= $1)`,
+			[]any{created},
+			nil,
+		},
+		{
+			"string slice",
+			nil,
+			map[string]any{"status": map[string]any{"$in": []string{"NEW", "OPEN"}}},
+			`("status" = ANY($1))`,
+			[]any{[]string{"NEW", "OPEN"}},
+			nil,
+		},
+		{
+			"json.Number value",
+			nil,
+			map[string]any{"id": json.Number("9007199254740993")},
+			`("id" = $1)`,
+			[]any{int64(9007199254740993)},
+			nil,
+		},
+		{
+			"typed $or",
+			nil,
+			map[string]any{"$or": []map[string]any{{"name": "John"}, {"name": "Doe"}}},
+			`(("name" = $1) OR ("name" = $2))`,
+			[]any{"John", "Doe"},
+			nil,
+		},
+		{
+			"slice of non scalars",
+			nil,
+			map[string]any{"status": map[string]any{"$in": []map[string]any{{"hacker": 1}}}},
+			``,
+			nil,
+			fmt.Errorf("invalid value for $in operator (must array of primatives): [map[hacker:1]]"),
+		},
+		{
+			"struct value",
+			nil,
+			map[string]any{"name": struct{}{}},
+			``,
+			nil,
+			fmt.Errorf("invalid comparison value (must be a primitive): {}"),
+		},
+		{
+			"empty filter",
+			nil,
+			map[string]any{},
+			`FALSE`,
+			nil,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.option == nil {
+				tt.option = []filter.Option{filter.WithAllowAllColumns()}
+			}
+			c, err := filter.NewConverter(tt.option...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conditions, values, err := c.ConvertMap(tt.input, 1)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertMap() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertMap() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if conditions != tt.conditions {
+				t.Errorf("Converter.ConvertMap() conditions:\n%v\nwant:\n%v", conditions, tt.conditions)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("Converter.ConvertMap() values:\n%#v\nwant:\n%#v", values, tt.values)
+			}
+		})
+	}
+}
+
+// testArray mimics pq.Array without depending on github.com/lib/pq.
+type testArray struct {
+	a any
+}
+
+func (a testArray) Value() (driver.Value, error) { return fmt.Sprint(a.a), nil }
+func (a testArray) Scan(any) error               { return nil }
+
+func testArrayDriver(a any) interface {
+	driver.Valuer
+	sql.Scanner
+} {
+	return testArray{a}
+}
+
+func TestConverter_ConvertMap_doesNotModifyFilter(t *testing.T) {
+	c, _ := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithArrayDriver(testArrayDriver))
+	in := map[string]any{"status": map[string]any{"$in": []any{"NEW", "OPEN"}}}
+	if _, _, err := c.ConvertMap(in, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"status": map[string]any{"$in": []any{"NEW", "OPEN"}}}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("Converter.ConvertMap() modified filter: %#v", in)
+	}
+}
+
+func TestConverter_ConvertReader(t *testing.T) {
+	c, _ := filter.NewConverter(filter.WithAllowAllColumns())
+	conditions, values, err := c.ConvertReader(strings.NewReader(`{"name": "John"}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `("name" = $1)`; conditions != want {
+		t.Errorf("Converter.ConvertReader() conditions = %v, want %v", conditions, want)
+	}
+	if !reflect.DeepEqual(values, []any{"John"}) {
+		t.Errorf("Converter.ConvertReader() values = %v, want %v", values, []any{"John"})
+	}
+
+	conditions, _, err = c.ConvertReader(strings.NewReader(``), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FALSE"; conditions != want {
+		t.Errorf("Converter.ConvertReader() conditions = %v, want %v", conditions, want)
+	}
+
+	if _, _, err = c.ConvertReader(strings.NewReader(`{"name": `), 1); err == nil {
+		t.Error("Converter.ConvertReader() error = nil, want error")
+	}
+}
+
+func TestConverter_Convert_rawMessage(t *testing.T) {
+	var body struct {
+		Filter json.RawMessage `json:"filter"`
+	}
+	if err := json.Unmarshal([]byte(`{"filter": {"name": "John"}}`), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := filter.NewConverter(filter.WithAllowAllColumns())
+	conditions, values, err := c.Convert(body.Filter, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `("name" = $1)`; conditions != want {
+		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
+	}
+	if !reflect.DeepEqual(values, []any{"John"}) {
+		t.Errorf("Converter.Convert() values = %v, want %v", values, []any{"John"})
+	}
+}
+
+func TestConverter_WithEmptyCondition(t *testing.T) {
+	c, _ := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithEmptyCondition("TRUE"))
+	conditions, values, err := c.Convert([]byte(`{}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "TRUE"; conditions != want {
+		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
+	}
+	if len(values) != 0 {
+		t.Errorf("Converter.Convert() values = %v, want nil", values)
+	}
+}
+
+func TestConverter_NoConstructor(t *testing.T) {
+	c := &filter.Converter{}
+
+	conditions, values, err := c.Convert([]byte(``), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FALSE"; conditions != want {
+		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
+	}
+	if len(values) != 0 {
+		t.Errorf("Converter.Convert() values = %v, want nil", values)
+	}
+
+	_, _, err = c.Convert([]byte(`{"name": "John"}`), 1)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestConverter_CopyReference(t *testing.T) {
+	c := filter.Converter{}
+	conditions, _, err := c.Convert([]byte(``), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FALSE"; conditions != want {
+		t.Errorf("Converter.Convert() conditions = %v, want %v", conditions, want)
+	}
+}
+
+func TestConverter_RequireAccessControl(t *testing.T) {
+	if _, err := filter.NewConverter(); err != filter.ErrNoAccessOption {
+		t.Errorf("NewConverter() error = %v, want %v", err, filter.ErrNoAccessOption)
+	}
+	if _, err := filter.NewConverter(filter.WithPlaceholderName("___test___")); err != filter.ErrNoAccessOption {
+		t.Errorf("NewConverter() error = %v, want %v", err, filter.ErrNoAccessOption)
+	}
+	if _, err := filter.NewConverter(filter.WithAllowAllColumns()); err != nil {
+		t.Errorf("NewConverter() error = %v, want no error", err)
+	}
+	if _, err := filter.NewConverter(filter.WithAllowColumns("name", "map")); err != nil {
+		t.Errorf("NewConverter() error = %v, want no error", err)
+	}
+	if _, err := filter.NewConverter(filter.WithAllowColumns()); err != nil {
+		t.Errorf("NewConverter() error = %v, want no error", err)
+	}
+	if _, err := filter.NewConverter(filter.WithNestedJSONB("meta", "created_at", "updated_at")); err != nil {
+		t.Errorf("NewConverter() error = %v, want no error", err)
+	}
+	if _, err := filter.NewConverter(filter.WithDisallowColumns("password")); err != nil {
+		t.Errorf("NewConverter() error = %v, want no error", err)
+	}
+}
+
+func TestConverter_AccessControl(t *testing.T) {
+	f := func(in string, wantErr error, options ...filter.Option) func(t *testing.T) {
+		t.Helper()
+		return func(t *testing.T) {
+			t.Helper()
+			c := &filter.Converter{}
+			if options != nil {
+				c, _ = filter.NewConverter(options...)
+				// requirement of access control is tested above.
+			}
+			q, _, err := c.Convert([]byte(in), 1)
+			t.Log(in, "->", q, err)
+			if wantErr == nil && err != nil {
+				t.Fatalf("no error returned, expected error: %v", err)
+			} else if wantErr != nil && err == nil {
+				t.Fatalf("expected error: %v", wantErr)
+			} else if wantErr != nil && wantErr.Error() != err.Error() {
+				t.Fatalf("error mismatch: %v != %v", err, wantErr)
+			}
+		}
+	}
+
+	no := func(c string) error { return filter.ColumnNotAllowedError{Column: c} }
+
+	t.Run("allow all, single root field",
+		f(`{"name":"John"}`, nil, filter.WithAllowAllColumns()))
+
+	t.Run("allow name, single allowed root field",
+		f(`{"name":"John"}`, nil, filter.WithAllowColumns("name")))
+
+	t.Run("allow name, single disallowed root field",
+		f(`{"password":"hacks"}`, no("password"), filter.WithAllowColumns("name")))
+
+	t.Run("allowed meta, single allowed nested field",
+		f(`{"map":"de_dust"}`, nil, filter.WithNestedJSONB("meta", "created_at")))
+
+	t.Run("allowed nested excemption, single allowed field",
+		f(`{"created_at":"de_dust"}`, nil, filter.WithNestedJSONB("meta", "created_at")))
+
+	t.Run("multi allow, single allowed root field",
+		f(`{"name":"John"}`, nil, filter.WithAllowColumns("name", "email")))
+
+	t.Run("multi allow, two allowed root fields",
+		f(`{"name":"John", "email":"test@example.org"}`, nil, filter.WithAllowColumns("name", "email")))
+
+	t.Run("multi allow, mixes access",
+		f(`{"name":"John", "password":"hacks"}`, no("password"), filter.WithAllowColumns("name", "email")))
+
+	t.Run("multi allow, mixes access",
+		f(`{"name":"John", "password":"hacks"}`, no("password"), filter.WithAllowColumns("name", "email")))
+
+	t.Run("allowed basic $and",
+		f(`{"$and": [{"name": "John"}, {"version": 3}]}`, nil, filter.WithAllowColumns("name", "version")))
+
+	t.Run("disallowed basic $and",
+		f(`{"$and": [{"name": "John"}, {"version": 3}]}`, no("version"), filter.WithAllowColumns("name")))
+
+	t.Run("allow all but one",
+		f(`{"name": "John"}`, nil, filter.WithAllowAllColumns(), filter.WithDisallowColumns("password")))
+
+	t.Run("allow all but one, failing",
+		f(`{"$and": [{"name": "John"}, {"password": "hacks"}]}`, no("password"), filter.WithAllowAllColumns(), filter.WithDisallowColumns("password")))
+
+	t.Run("nested but disallow password, allow exception",
+		f(`{"created_at": "1"}`, nil, filter.WithNestedJSONB("meta", "created_at"), filter.WithDisallowColumns("password")))
+
+	t.Run("nested but disallow password, allow nested",
+		f(`{"map": "de_dust"}`, nil, filter.WithNestedJSONB("meta", "created_at"), filter.WithDisallowColumns("password")))
+
+	t.Run("nested but disallow password, disallow",
+		f(`{"password": "hacks"}`, no("password"), filter.WithNestedJSONB("meta", "created_at"), filter.WithDisallowColumns("password")))
+}
+
+func TestConverter_ConvertOrderBy(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []filter.Option
+		input    string
+		expected string
+		err      error
+	}{
+		{
+			"single field ascending",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": 1}`,
+			`"playerCount" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"single field descending",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": -1}`,
+			`"playerCount" DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"multiple fields",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": -1, "name": 1}`,
+			`"playerCount" DESC NULLS LAST, "name" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"nested JSONB single field ascending",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
+			`{"map": 1}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS LAST, "customdata"->>'map' ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"nested JSONB single field descending",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
+			`{"map": -1}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) DESC NULLS LAST, "customdata"->>'map' DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"nested JSONB multiple fields",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
+			`{"map": 1, "bar": -1}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS LAST, "customdata"->>'map' ASC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'bar') = 'number' THEN ("customdata"->>'bar')::numeric END) DESC NULLS LAST, "customdata"->>'bar' DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"mixed nested and regular fields",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
+			`{"created_at": 1, "map": -1}`,
+			`"created_at" ASC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) DESC NULLS LAST, "customdata"->>'map' DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"nested JSONB declared timestamp field",
+			[]filter.Option{filter.WithNestedJSONB("customdata"), filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp})},
+			`{"last_seen": -1}`,
+			timestamptz(`"customdata"->>'last_seen'`) + ` DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"field name with spaces",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"my_field": 1}`,
+			`"my_field" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"empty object",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{}`,
+			``,
+			nil,
+		},
+		{
+			"invalid field name for SQL injection",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"my field": 1}`,
+			``,
+			fmt.Errorf("invalid column name: my field"),
+		},
+		{
+			"invalid direction value",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": 2}`,
+			``,
+			filter.InvalidOrderDirectionError{Field: "playerCount", Value: json.Number("2")},
+		},
+		{
+			"direction strings",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": "asc", "name": "DESC", "level": "ascending", "map": "descending"}`,
+			`"playerCount" ASC NULLS LAST, "name" DESC NULLS LAST, "level" ASC NULLS LAST, "map" DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"float directions",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": 1.0, "name": -1.0}`,
+			`"playerCount" ASC NULLS LAST, "name" DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"invalid float direction",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": 1.5}`,
+			``,
+			filter.InvalidOrderDirectionError{Field: "playerCount", Value: json.Number("1.5")},
+		},
+		{
+			"invalid direction string",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": "up"}`,
+			``,
+			fmt.Errorf(`invalid order direction for field playerCount: up (must be 1, -1, "asc" or "desc")`),
+		},
+		{
+			"nulls first",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": {"direction": -1, "nulls": "first"}, "name": {"direction": "asc"}}`,
+			`"playerCount" DESC NULLS FIRST, "name" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"default nulls",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithDefaultNulls(filter.NullsFirst)},
+			`{"playerCount": -1, "name": {"direction": 1, "nulls": "last"}}`,
+			`"playerCount" DESC NULLS FIRST, "name" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"nulls first on JSONB field",
+			[]filter.Option{filter.WithNestedJSONB("customdata")},
+			`{"map": {"direction": 1, "nulls": "first"}}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS FIRST, "customdata"->>'map' ASC NULLS FIRST`,
+			nil,
+		},
+		{
+			"invalid nulls",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": {"direction": 1, "nulls": "middle"}}`,
+			``,
+			fmt.Errorf(`invalid nulls for field playerCount: middle (must be "first" or "last")`),
+		},
+		{
+			"object without direction",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": {"nulls": "first"}}`,
+			``,
+			filter.InvalidOrderDirectionError{Field: "playerCount"},
+		},
+		{
+			"unknown sort option",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"playerCount": {"direction": 1, "locale": "en"}}`,
+			``,
+			fmt.Errorf("invalid sort option for field playerCount: locale (must be direction, nulls, collation, caseInsensitive or type)"),
+		},
+		{
+			"type hints",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
+			`{"score": {"direction": -1, "type": "numeric"}, "active": {"type": "boolean", "direction": 1}, "seen": {"direction": 1, "type": "timestamptz"}, "map": {"direction": 1, "type": "text", "caseInsensitive": true}, "created_at": {"direction": 1, "type": "numeric"}}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'score') = 'number' THEN ("customdata"->>'score')::numeric END) DESC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'active') = 'boolean' THEN ("customdata"->>'active')::boolean END) ASC NULLS LAST, ` + timestamptz(`"customdata"->>'seen'`) + ` ASC NULLS LAST, lower("customdata"->>'map') ASC NULLS LAST, "created_at" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"declared types",
+			[]filter.Option{filter.WithNestedJSONB("customdata"), filter.WithFieldTypes(map[string]filter.FieldType{"score": filter.FieldTypeNumeric, "name": filter.FieldTypeText})},
+			`{"score": -1, "name": 1, "map": {"direction": 1, "type": "numeric"}}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'score') = 'number' THEN ("customdata"->>'score')::numeric END) DESC NULLS LAST, "customdata"->>'name' ASC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"hint overrides declared type",
+			[]filter.Option{filter.WithNestedJSONB("customdata"), filter.WithFieldTypes(map[string]filter.FieldType{"score": filter.FieldTypeNumeric})},
+			`{"score": {"direction": 1, "type": "text"}}`,
+			`"customdata"->>'score' ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"invalid type hint",
+			[]filter.Option{filter.WithNestedJSONB("customdata")},
+			`{"score": {"direction": 1, "type": "geometry"}}`,
+			``,
+			fmt.Errorf("invalid type for field score: geometry (must be numeric, timestamptz, boolean or text)"),
+		},
+		{
+			"collation",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "name"), filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText}), filter.WithCollations("und-x-icu")},
+			`{"name": {"direction": 1, "collation": "und-x-icu"}, "map": {"direction": -1, "caseInsensitive": true}}`,
+			`"name" COLLATE "und-x-icu" ASC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) DESC NULLS LAST, lower("customdata"->>'map') DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"default collation",
+			[]filter.Option{filter.WithNestedJSONB("customdata", "name", "level", "title"), filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText, "title": filter.FieldTypeText}), filter.WithDefaultCollation("en-x-icu")},
+			`{"map": 1, "level": -1, "name": {"direction": 1, "collation": "en-x-icu", "caseInsensitive": true}, "title": 1}`,
+			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS LAST, "customdata"->>'map' COLLATE "en-x-icu" ASC NULLS LAST, "level" DESC NULLS LAST, lower("name") COLLATE "en-x-icu" ASC NULLS LAST, "title" COLLATE "en-x-icu" ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"collation on non-text column",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithCollations("C")},
+			`{"created_at": {"direction": 1, "collation": "C"}}`,
+			``,
+			fmt.Errorf("collation not allowed for field created_at: C (must be a nested field or declared as text with WithFieldTypes)"),
+		},
+		{
+			"collation not allowed",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithCollations("und-x-icu")},
+			`{"name": {"direction": 1, "collation": "C\" ASC, (SELECT 1) --"}}`,
+			``,
+			fmt.Errorf(`collation not allowed for field name: C" ASC, (SELECT 1) --`),
+		},
+		{
+			"invalid caseInsensitive",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"name": {"direction": 1, "caseInsensitive": "yes"}}`,
+			``,
+			fmt.Errorf("invalid caseInsensitive for field name: yes (must be true or false)"),
+		},
+		{
+			"disallowed column",
+			[]filter.Option{filter.WithAllowColumns("name")},
+			`{"playerCount": 1}`,
+			``,
+			filter.ColumnNotAllowedError{Column: "playerCount"},
+		},
+		{
+			"expression without values",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"free": {"$expr": {"$subtract": ["$maxPlayers", "$playerCount"]}, "direction": -1}}`,
+			`("maxPlayers" - "playerCount") DESC NULLS LAST`,
+			nil,
+		},
+		{
+			"$order needs values",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"status": {"$order": ["live", "ended"]}}`,
+			``,
+			fmt.Errorf("sort object with values (like $order) requires ConvertOrderByWithValues"),
+		},
+		{
+			"distance to a point",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"location": filter.FieldTypeGeography, "area": filter.FieldTypeGeometry, "pos": filter.FieldTypePoint})},
+			`{"location": {"$near": [4.9, 52.4]}, "pos": {"$near": {"type": "Point", "coordinates": [1, -2e-3]}}}`,
+			`"location" <-> ST_SetSRID(ST_MakePoint(4.9, 52.4), 4326)::geography ASC NULLS LAST, "pos" <-> point(1, -0.002) ASC NULLS LAST`,
+			nil,
+		},
+		{
+			"textScore without $text",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithTextSearch("title")},
+			`{"score": {"$meta": "textScore"}}`,
+			``,
+			fmt.Errorf("sorting on textScore requires a $text operator in the filter"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter, err := filter.NewConverter(tt.options...)
+			if err != nil {
+				t.Fatalf("Failed to create converter: %v", err)
+			}
+
+			result, err := converter.ConvertOrderBy([]byte(tt.input))
+
+			if tt.err != nil {
+				if err == nil {
+					t.Fatalf("Expected error %v, got nil", tt.err)
+				}
+				if err.Error() != tt.err.Error() {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestConverter_ConvertOrderByWithValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		orderBy string
+		values  []any
+		err     error
+	}{
+		{
+			"expression",
+			`{"freeSlots": {"$expr": {"$subtract": ["$maxPlayers", "$playerCount"]}, "direction": -1}, "name": 1}`,
+			`(("meta"->>'maxPlayers')::numeric - ("meta"->>'playerCount')::numeric) DESC NULLS LAST, "name" ASC NULLS LAST`,
+			nil,
+			nil,
+		},
+		{
+			"expression with literal",
+			`{"score": {"$expr": {"$multiply": ["$level", 2]}}}`,
+			`("level" * $2::numeric) ASC NULLS LAST`,
+			[]any{int64(2)},
+			nil,
+		},
+		{
+			"size",
+			`{"hats": {"$expr": {"$size": "$hats"}, "direction": "desc"}, "tags": {"$expr": {"$size": "$tags"}, "direction": 1, "nulls": "first"}}`,
+			`(CASE WHEN jsonb_typeof("meta"->'hats') = 'array' THEN jsonb_array_length("meta"->'hats') END) DESC NULLS LAST, cardinality("tags") ASC NULLS FIRST`,
+			nil,
+			nil,
+		},
+		{
+			"order",
+			`{"status": {"$order": ["live", "starting", "ended"]}, "mode": {"$order": ["ranked"], "direction": -1}}`,
+			`CASE "meta"->>'status' WHEN $2 THEN 0 WHEN $3 THEN 1 WHEN $4 THEN 2 ELSE 3 END ASC NULLS LAST, CASE "meta"->>'mode' WHEN $5 THEN 0 ELSE 1 END DESC NULLS LAST`,
+			[]any{"live", "starting", "ended", "ranked"},
+			nil,
+		},
+		{
+			"order on column",
+			`{"level": {"$order": [10, 5]}}`,
+			`CASE "level" WHEN $2 THEN 0 WHEN $3 THEN 1 ELSE 2 END ASC NULLS LAST`,
+			[]any{int64(10), int64(5)},
+			nil,
+		},
+		{
+			"numbers above 2^53",
+			`{"level": {"$order": [9007199254740993, 0.1]}, "score": {"$expr": {"$add": ["$level", 9007199254740993]}}}`,
+			`CASE "level" WHEN $2 THEN 0 WHEN $3 THEN 1 ELSE 2 END ASC NULLS LAST, ("level" + $4::numeric) ASC NULLS LAST`,
+			[]any{int64(9007199254740993), filter.Decimal("0.1"), int64(9007199254740993)},
+			nil,
+		},
+		{
+			"disallowed field in expression",
+			`{"x": {"$expr": {"$add": ["$password", 1]}}}`,
+			``,
+			nil,
+			filter.ColumnNotAllowedError{Column: "password"},
+		},
+		{
+			"disallowed field in order",
+			`{"password": {"$order": ["hunter2"]}}`,
+			``,
+			nil,
+			filter.ColumnNotAllowedError{Column: "password"},
+		},
+		{
+			"literal expression",
+			`{"x": {"$expr": 1}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid $expr for sort key x (must be a field or an expression): 1"),
+		},
+		{
+			"empty order",
+			`{"status": {"$order": []}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $order of field status (must be non-empty array of primitives): []"),
+		},
+		{
+			"unsupported expression",
+			`{"x": {"$expr": {"$sqrt": "$level"}}}`,
+			``,
+			nil,
+			fmt.Errorf("unsupported $expr operator: $sqrt"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := filter.NewConverter(filter.WithNestedJSONB("meta", "name", "level", "tags"), filter.WithDisallowColumns("password"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			orderBy, values, err := c.ConvertOrderByWithValues([]byte(tt.input), 2)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertOrderByWithValues() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertOrderByWithValues() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if orderBy != tt.orderBy {
+				t.Errorf("Converter.ConvertOrderByWithValues():\n%v\nwant:\n%v", orderBy, tt.orderBy)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("Converter.ConvertOrderByWithValues() values = %#v, want %#v", values, tt.values)
+			}
+		})
+	}
+}
+
+func TestConverter_ConvertWithOrderBy(t *testing.T) {
+	c, err := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithTextSearch("title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conditions, orderBy, values, err := c.ConvertWithOrderBy([]byte(`{"level": 10, "$text": {"$search": "dragon"}}`), []byte(`{"score": {"$meta": "textScore"}, "name": 1}`), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `((to_tsvector('english', "title") @@ websearch_to_tsquery('english', $2)) AND ("level" = $3))`; conditions != want {
+		t.Errorf("conditions = %q, want %q", conditions, want)
+	}
+	if want := `ts_rank(to_tsvector('english', "title"), websearch_to_tsquery('english', $4)) DESC, "name" ASC NULLS LAST`; orderBy != want {
+		t.Errorf("orderBy = %q, want %q", orderBy, want)
+	}
+	if want := []any{"dragon", int64(10), "dragon"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %#v, want %#v", values, want)
+	}
+
+	conditions, orderBy, values, err = c.ConvertWithOrderBy(nil, []byte(`{"name": -1}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conditions != "FALSE" || orderBy != `"name" DESC NULLS LAST` || len(values) != 0 {
+		t.Errorf("unexpected result for empty filter: %q, %q, %v", conditions, orderBy, values)
+	}
+
+	if _, _, _, err := c.ConvertWithOrderBy([]byte(`{"level": 10}`), []byte(`{"score": {"$meta": "textScore"}}`), 1); err == nil {
+		t.Error("expected an error for textScore without $text")
+	}
+}
+
+func TestConverter_ConvertKeyset(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []filter.Option
+		sort       string
+		tiebreaker string
+		after      map[string]any
+		conditions string
+		orderBy    string
+		values     []any
+		err        error
+	}{
+		{
+			"first page",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"level": -1}`,
+			"id",
+			nil,
+			`TRUE`,
+			`"level" DESC NULLS LAST, "id" ASC NULLS LAST`,
+			nil,
+			nil,
+		},
+		{
+			"descending column",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"level": -1}`,
+			"id",
+			map[string]any{"level": json.Number("10"), "id": json.Number("4")},
+			`(("level" < $2 OR "level" IS NULL) OR ("level" = $2 AND "id" > $3))`,
+			`"level" DESC NULLS LAST, "id" ASC NULLS LAST`,
+			[]any{int64(10), int64(4)},
+			nil,
+		},
+		{
+			"NULL value",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"mount": 1, "name": 1}`,
+			"id",
+			map[string]any{"mount": nil, "name": "Bob", "id": 2},
+			`(("mount" IS NULL AND ("name" > $2 OR "name" IS NULL)) OR ("mount" IS NULL AND "name" = $2 AND "id" > $3))`,
+			`"mount" ASC NULLS LAST, "name" ASC NULLS LAST, "id" ASC NULLS LAST`,
+			[]any{"Bob", 2},
+			nil,
+		},
+		{
+			"tiebreaker in sort",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"id": -1}`,
+			"id",
+			map[string]any{"id": 2},
+			`("id" < $2)`,
+			`"id" DESC NULLS LAST`,
+			[]any{2},
+			nil,
+		},
+		{
+			"nested jsonb field",
+			[]filter.Option{filter.WithNestedJSONB("meta", "id", "created_at"), filter.WithFieldTypes(map[string]filter.FieldType{"created_at": filter.FieldTypeTimestamp})},
+			`{"score": 1, "created_at": -1}`,
+			"id",
+			map[string]any{"score": json.Number("1.5"), "created_at": "2024-01-01T00:00:00Z", "id": 7},
+			`(((CASE WHEN jsonb_typeof("meta"->'score') = 'number' THEN ("meta"->>'score')::numeric END) > $2 OR (CASE WHEN jsonb_typeof("meta"->'score') = 'number' THEN ("meta"->>'score')::numeric END) IS NULL) OR ((CASE WHEN jsonb_typeof("meta"->'score') = 'number' THEN ("meta"->>'score')::numeric END) = $2 AND ("meta"->>'score' > $3 OR "meta"->>'score' IS NULL)) OR ((CASE WHEN jsonb_typeof("meta"->'score') = 'number' THEN ("meta"->>'score')::numeric END) = $2 AND "meta"->>'score' = $3 AND ("created_at" < $4 OR "created_at" IS NULL)) OR ((CASE WHEN jsonb_typeof("meta"->'score') = 'number' THEN ("meta"->>'score')::numeric END) = $2 AND "meta"->>'score' = $3 AND "created_at" = $4 AND "id" > $5))`,
+			`(CASE WHEN jsonb_typeof("meta"->'score') = 'number' THEN ("meta"->>'score')::numeric END) ASC NULLS LAST, "meta"->>'score' ASC NULLS LAST, "created_at" DESC NULLS LAST, "id" ASC NULLS LAST`,
+			[]any{filter.Decimal("1.5"), "1.5", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 7},
+			nil,
+		},
+		{
+			"missing tiebreaker value",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`{"level": 1}`,
+			"id",
+			map[string]any{"level": 1},
+			``,
+			``,
+			nil,
+			fmt.Errorf("keyset value for tiebreaker id can't be null"),
+		},
+		{
+			"disallowed tiebreaker",
+			[]filter.Option{filter.WithAllowColumns("level")},
+			`{"level": 1}`,
+			"id",
+			nil,
+			``,
+			``,
+			nil,
+			filter.ColumnNotAllowedError{Column: "id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := filter.NewConverter(tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conditions, orderBy, values, err := c.ConvertKeyset([]byte(tt.sort), tt.tiebreaker, tt.after, 2)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertKeyset() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertKeyset() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if conditions != tt.conditions {
+				t.Errorf("Converter.ConvertKeyset() conditions:\n%v\nwant:\n%v", conditions, tt.conditions)
+			}
+			if orderBy != tt.orderBy {
+				t.Errorf("Converter.ConvertKeyset() orderBy:\n%v\nwant:\n%v", orderBy, tt.orderBy)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("Converter.ConvertKeyset() values:\n%#v\nwant:\n%#v", values, tt.values)
+			}
+		})
+	}
+}
 
-	t.Run("disallowed basic $and",
-		f(`{"$and": [{"name": "John"}, {"version": 3}]}`, no("version"), filter.WithAllowColumns("name")))
+func TestCursor(t *testing.T) {
+	secret := []byte("secret")
+	cursor, err := filter.EncodeCursor(secret, map[string]any{"level": 10, "name": "Bob", "id": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	t.Run("allow all but one",
-		f(`{"name": "John"}`, nil, filter.WithAllowAllColumns(), filter.WithDisallowColumns("password")))
+	values, err := filter.DecodeCursor(secret, cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"level": json.Number("10"), "name": "Bob", "id": json.Number("4")}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("DecodeCursor() = %#v, want %#v", values, expected)
+	}
 
-	t.Run("allow all but one, failing",
-		f(`{"$and": [{"name": "John"}, {"password": "hacks"}]}`, no("password"), filter.WithAllowAllColumns(), filter.WithDisallowColumns("password")))
+	if _, err := filter.DecodeCursor([]byte("other secret"), cursor); err != filter.ErrInvalidCursor {
+		t.Errorf("DecodeCursor() with other secret error = %v, want %v", err, filter.ErrInvalidCursor)
+	}
+	payload, signature, _ := strings.Cut(cursor, ".")
+	if _, err := filter.DecodeCursor(secret, payload[1:]+"."+signature); err != filter.ErrInvalidCursor {
+		t.Errorf("DecodeCursor() with changed payload error = %v, want %v", err, filter.ErrInvalidCursor)
+	}
+	if _, err := filter.DecodeCursor(secret, "invalid"); err != filter.ErrInvalidCursor {
+		t.Errorf("DecodeCursor() of invalid cursor error = %v, want %v", err, filter.ErrInvalidCursor)
+	}
+}
 
-	t.Run("nested but disallow password, allow exception",
-		f(`{"created_at": "1"}`, nil, filter.WithNestedJSONB("meta", "created_at"), filter.WithDisallowColumns("password")))
+func TestConverter_ConvertFind(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []filter.Option
+		input   string
+		find    filter.FindQuery
+		err     error
+	}{
+		{
+			"all fields",
+			[]filter.Option{filter.WithNestedJSONB("meta", "name", "level")},
+			`{"filter": {"level": {"$gt": 10}, "pet": "dog"}, "sort": {"level": -1}, "limit": 50, "skip": 100, "projection": {"name": 1, "pet": true}}`,
+			filter.FindQuery{
+				Select:      `"name", "meta"->'pet' AS "pet"`,
+				Conditions:  `(("level" > $2) AND ("meta"->>'pet' = $3))`,
+				OrderBy:     `"level" DESC NULLS LAST`,
+				LimitOffset: `LIMIT $4 OFFSET $5`,
+				Values:      []any{int64(10), "dog", int64(50), int64(100)},
+			},
+			nil,
+		},
+		{
+			"empty find",
+			nil,
+			`{}`,
+			filter.FindQuery{
+				Select:     `*`,
+				Conditions: `FALSE`,
+			},
+			nil,
+		},
+		{
+			"maximum limit without limit",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithMaxLimit(100), filter.WithEmptyCondition("TRUE")},
+			`{"sort": null, "skip": 10}`,
+			filter.FindQuery{
+				Select:      `*`,
+				Conditions:  `TRUE`,
+				LimitOffset: `LIMIT $2 OFFSET $3`,
+				Values:      []any{int64(100), int64(10)},
+			},
+			nil,
+		},
+		{
+			"textScore sort",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithTextSearchVector("search")},
+			`{"filter": {"$text": {"$search": "dragon"}}, "sort": {"score": {"$meta": "textScore"}}}`,
+			filter.FindQuery{
+				Select:     `*`,
+				Conditions: `("search" @@ websearch_to_tsquery('english', $2))`,
+				OrderBy:    `ts_rank("search", websearch_to_tsquery('english', $3)) DESC`,
+				Values:     []any{"dragon", "dragon"},
+			},
+			nil,
+		},
+		{
+			"limit above maximum",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithMaxLimit(100)},
+			`{"limit": 101}`,
+			filter.FindQuery{},
+			fmt.Errorf("invalid value for limit (must be at most 100): 101"),
+		},
+		{
+			"negative skip",
+			nil,
+			`{"skip": -1}`,
+			filter.FindQuery{},
+			fmt.Errorf("invalid value for skip (must be a non-negative integer): -1"),
+		},
+		{
+			"unknown field",
+			nil,
+			`{"hint": {"name": 1}}`,
+			filter.FindQuery{},
+			fmt.Errorf("unknown find field: hint"),
+		},
+		{
+			"disallowed projection column",
+			[]filter.Option{filter.WithAllowColumns("name")},
+			`{"projection": {"name": 1, "password": 1}}`,
+			filter.FindQuery{},
+			filter.ColumnNotAllowedError{Column: "password"},
+		},
+	}
 
-	t.Run("nested but disallow password, allow nested",
-		f(`{"map": "de_dust"}`, nil, filter.WithNestedJSONB("meta", "created_at"), filter.WithDisallowColumns("password")))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.options == nil {
+				tt.options = []filter.Option{filter.WithAllowAllColumns()}
+			}
+			c, err := filter.NewConverter(tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			find, err := c.ConvertFind([]byte(tt.input), 2)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertFind() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertFind() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if !reflect.DeepEqual(find, tt.find) {
+				t.Errorf("Converter.ConvertFind():\n%#v\nwant:\n%#v", find, tt.find)
+			}
+		})
+	}
+}
+
+func TestConverter_ConvertProjection(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []filter.Option
+		input      string
+		selectList string
+		err        error
+	}{
+		{
+			"inclusion",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level")},
+			`{"name": 1, "metadata.pet": 1, "guild_id": true, "_id": 0}`,
+			`"name", "metadata"->'pet' AS "pet", "metadata"->'guild_id' AS "guild_id"`,
+			nil,
+		},
+		{
+			"float values",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level")},
+			`{"name": 1.0, "level": 1.0, "_id": 0.0}`,
+			`"name", "level"`,
+			nil,
+		},
+		{
+			"float exclusion",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level"), filter.WithKnownColumns("id", "name", "level")},
+			`{"level": 0.0}`,
+			`"id", "name"`,
+			nil,
+		},
+		{
+			"nested column itself",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name")},
+			`{"name": 1, "metadata": 1}`,
+			`"name", "metadata"`,
+			nil,
+		},
+		{
+			"empty projection",
+			nil,
+			`{}`,
+			`*`,
+			nil,
+		},
+		{
+			"exclusion",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level", "password"), filter.WithDisallowColumns("secret"), filter.WithKnownColumns("id", "name", "level", "password", "secret", "metadata")},
+			`{"password": 0, "metadata.pet": 0, "guild_id": false}`,
+			`"id", "name", "level", "metadata" - 'pet' - 'guild_id' AS "metadata"`,
+			nil,
+		},
+		{
+			"exclusion of nested fields without the nested column",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level"), filter.WithKnownColumns("id", "name", "level")},
+			`{"metadata.secret": 0}`,
+			``,
+			fmt.Errorf("excluding nested fields requires the nested column metadata declared with WithKnownColumns"),
+		},
+		{
+			"exclusion without known columns",
+			nil,
+			`{"password": 0}`,
+			``,
+			fmt.Errorf("exclusion projections require the columns declared with WithKnownColumns"),
+		},
+		{
+			"inclusion and exclusion",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithKnownColumns("id", "name")},
+			`{"name": 1, "id": 0}`,
+			``,
+			fmt.Errorf("projection can't both include and exclude fields"),
+		},
+		{
+			"disallowed column",
+			[]filter.Option{filter.WithAllowColumns("name")},
+			`{"name": 1, "password": 1}`,
+			``,
+			filter.ColumnNotAllowedError{Column: "password"},
+		},
+		{
+			"disallowed nested field",
+			[]filter.Option{filter.WithNestedJSONB("metadata"), filter.WithDisallowColumns("secret")},
+			`{"metadata.secret": 1}`,
+			``,
+			filter.ColumnNotAllowedError{Column: "secret"},
+		},
+		{
+			"invalid field",
+			[]filter.Option{filter.WithNestedJSONB("metadata")},
+			`{"metadata.stats.kills": 1}`,
+			``,
+			fmt.Errorf("invalid column name: metadata.stats.kills"),
+		},
+		{
+			"expression",
+			nil,
+			`{"name": {"$toLower": "$name"}}`,
+			``,
+			fmt.Errorf("invalid value for projection field name: map[$toLower:$name] (must be 1, 0, true or false)"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.options == nil {
+				tt.options = []filter.Option{filter.WithAllowAllColumns()}
+			}
+			c, err := filter.NewConverter(tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selectList, err := c.ConvertProjection([]byte(tt.input))
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertProjection() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertProjection() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if selectList != tt.selectList {
+				t.Errorf("Converter.ConvertProjection():\n%v\nwant:\n%v", selectList, tt.selectList)
+			}
+		})
+	}
+}
+
+func TestConverter_ConvertUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		set    string
+		values []any
+		err    error
+	}{
+		{
+			"set and inc",
+			`{"$set": {"name": "John", "pet": "dog", "stats": {"kills": 1}}, "$inc": {"level": 1, "guild_id": 2}}`,
+			`"level" = COALESCE("level", 0) + $2, "name" = $3, "metadata" = jsonb_set(jsonb_set(jsonb_set(COALESCE("metadata", '{}'), '{guild_id}', to_jsonb(COALESCE(("metadata"->>'guild_id')::numeric, 0) + $1::numeric)), '{pet}', $4::jsonb), '{stats}', $5::jsonb)`,
+			[]any{int64(2), int64(1), "John", `"dog"`, `{"kills":1}`},
+			nil,
+		},
+		{
+			"set nested column",
+			`{"$set": {"metadata": {"pet": "dog"}, "name": "John"}}`,
+			`"metadata" = $1::jsonb, "name" = $2`,
+			[]any{`{"pet":"dog"}`, "John"},
+			nil,
+		},
+		{
+			"unset nested column",
+			`{"$unset": {"metadata": ""}}`,
+			`"metadata" = NULL`,
+			nil,
+			nil,
+		},
+		{
+			"nested column and its fields",
+			`{"$set": {"metadata": {"pet": "dog"}}, "$inc": {"score": 1}}`,
+			``,
+			nil,
+			fmt.Errorf("conflicting update operators for field: metadata"),
+		},
+		{
+			"fields and the nested column",
+			`{"$set": {"metadata": {"pet": "dog"}, "score": 1}}`,
+			``,
+			nil,
+			fmt.Errorf("conflicting update operators for field: metadata"),
+		},
+		{
+			"unset",
+			`{"$unset": {"name": "", "pet": ""}}`,
+			`"name" = NULL, "metadata" = COALESCE("metadata", '{}') #- '{pet}'`,
+			nil,
+			nil,
+		},
+		{
+			"min and max",
+			`{"$min": {"level": 5}, "$max": {"score": 3}}`,
+			`"level" = LEAST("level", $2), "metadata" = jsonb_set(COALESCE("metadata", '{}'), '{score}', to_jsonb(GREATEST(("metadata"->>'score')::numeric, $1::numeric)))`,
+			[]any{int64(3), int64(5)},
+			nil,
+		},
+		{
+			"mul",
+			`{"$mul": {"level": 2}}`,
+			`"level" = COALESCE("level", 0) * $1`,
+			[]any{int64(2)},
+			nil,
+		},
+		{
+			"push",
+			`{"$push": {"tags": "a", "hats": "cap"}}`,
+			`"tags" = array_append("tags", $2), "metadata" = jsonb_set(COALESCE("metadata", '{}'), '{hats}', COALESCE("metadata"->'hats', '[]') || $1::jsonb)`,
+			[]any{`["cap"]`, "a"},
+			nil,
+		},
+		{
+			"push each",
+			`{"$push": {"tags": {"$each": ["a", "b"]}}}`,
+			`"tags" = "tags" || $1`,
+			[]any{[]any{"a", "b"}},
+			nil,
+		},
+		{
+			"add to set",
+			`{"$addToSet": {"tags": "a", "hats": {"$each": ["cap", "cap"]}}}`,
+			`"tags" = (CASE WHEN $2 = ANY("tags") THEN "tags" ELSE array_append("tags", $2) END), "metadata" = jsonb_set(COALESCE("metadata", '{}'), '{hats}', COALESCE("metadata"->'hats', '[]') || COALESCE((SELECT jsonb_agg(__filter_placeholder ORDER BY ordinality) FROM jsonb_array_elements($1::jsonb) WITH ORDINALITY AS __filter_placeholder WHERE __filter_placeholder <> ALL(ARRAY(SELECT jsonb_array_elements(COALESCE("metadata"->'hats', '[]'))))), '[]'))`,
+			[]any{`["cap"]`, "a"},
+			nil,
+		},
+		{
+			"add to set each",
+			`{"$addToSet": {"tags": {"$each": ["a", "b", "a"]}}}`,
+			`"tags" = COALESCE("tags", '{}') || ARRAY(SELECT __filter_placeholder FROM unnest(COALESCE("tags", '{}') || $1) WITH ORDINALITY AS __filter_placeholder WHERE ordinality > cardinality(COALESCE("tags", '{}')) AND __filter_placeholder <> ALL(COALESCE("tags", '{}')) ORDER BY ordinality)`,
+			[]any{[]any{"a", "b"}},
+			nil,
+		},
+		{
+			"pull",
+			`{"$pull": {"tags": "a", "keys": 3}}`,
+			`"tags" = array_remove("tags", $2), "metadata" = jsonb_set_lax(COALESCE("metadata", '{}'), '{keys}', (CASE WHEN jsonb_typeof("metadata"->'keys') = 'array' THEN COALESCE((SELECT jsonb_agg(__filter_placeholder ORDER BY ordinality) FROM jsonb_array_elements("metadata"->'keys') WITH ORDINALITY AS __filter_placeholder WHERE NOT COALESCE(__filter_placeholder = $1::jsonb, FALSE)), '[]') END), true, 'return_target')`,
+			[]any{"3", "a"},
+			nil,
+		},
+		{
+			"pull with condition",
+			`{"$pull": {"tags": {"$in": ["a", "b"]}, "keys": {"$gte": 4}}}`,
+			`"tags" = ARRAY(SELECT __filter_placeholder FROM unnest("tags") AS __filter_placeholder WHERE NOT COALESCE(("__filter_placeholder"::text = ANY($2)), FALSE)), "metadata" = jsonb_set_lax(COALESCE("metadata", '{}'), '{keys}', (CASE WHEN jsonb_typeof("metadata"->'keys') = 'array' THEN COALESCE((SELECT jsonb_agg(__filter_placeholder ORDER BY ordinality) FROM jsonb_array_elements("metadata"->'keys') WITH ORDINALITY AS __filter_placeholder WHERE NOT COALESCE((("__filter_placeholder" #>> '{}')::numeric >= $1), FALSE)), '[]') END), true, 'return_target')`,
+			[]any{int64(4), []any{"a", "b"}},
+			nil,
+		},
+		{
+			"current date",
+			`{"$currentDate": {"last_seen": true, "updated": {"$type": "date"}}}`,
+			`"last_seen" = CURRENT_TIMESTAMP, "metadata" = jsonb_set(COALESCE("metadata", '{}'), '{updated}', to_jsonb(CURRENT_TIMESTAMP))`,
+			nil,
+			nil,
+		},
+		{
+			"current date timestamp",
+			`{"$currentDate": {"last_seen": {"$type": "timestamp"}}}`,
+			``,
+			nil,
+			fmt.Errorf(`invalid value for $currentDate operator (must be true or {"$type": "date"}): map[$type:timestamp]`),
+		},
+		{
+			"rename",
+			`{"$rename": {"pet": "animal", "name": "level"}}`,
+			`"level" = "name", "name" = NULL, "metadata" = jsonb_set_lax(COALESCE("metadata", '{}') #- '{pet}', '{animal}', "metadata"->'pet', true, 'return_target')`,
+			nil,
+			nil,
+		},
+		{
+			"rename to nested field",
+			`{"$rename": {"name": "pet"}}`,
+			``,
+			nil,
+			fmt.Errorf("$rename between the nested JSONB column and other columns not supported: name"),
+		},
+		{
+			"conflicting operators",
+			`{"$set": {"level": 1}, "$inc": {"level": 1}}`,
+			``,
+			nil,
+			fmt.Errorf("conflicting update operators for field: level"),
+		},
+		{
+			"replacement document",
+			`{"name": "John"}`,
+			``,
+			nil,
+			fmt.Errorf("update must only contain update operators: name"),
+		},
+		{
+			"unknown operator",
+			`{"$setOnInsert": {"name": "John"}}`,
+			``,
+			nil,
+			fmt.Errorf("unknown update operator: $setOnInsert"),
+		},
+		{
+			"invalid inc",
+			`{"$inc": {"level": "1"}}`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $inc operator (must be a number): 1"),
+		},
+		{
+			"not writable",
+			`{"$set": {"id": 1}}`,
+			``,
+			nil,
+			filter.ColumnNotWritableError{Column: "id"},
+		},
+		{
+			"disallowed",
+			`{"$set": {"password": "hunter2"}}`,
+			``,
+			nil,
+			filter.ColumnNotWritableError{Column: "password"},
+		},
+		{
+			"empty",
+			`{}`,
+			``,
+			nil,
+			fmt.Errorf("empty objects not allowed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := filter.NewConverter(
+				filter.WithNestedJSONB("metadata", "id", "name", "level", "tags", "last_seen", "password"),
+				filter.WithDisallowColumns("password"),
+				filter.WithWritableColumns("name", "level", "tags", "last_seen", "metadata"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set, values, err := c.ConvertUpdate([]byte(tt.input), 1)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertUpdate() error = %v, wantErr %v", err, tt.err)
+				return
+			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertUpdate() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if set != tt.set {
+				t.Errorf("Converter.ConvertUpdate() set:\n%v\nwant:\n%v", set, tt.set)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("Converter.ConvertUpdate() values = %#v, want %#v", values, tt.values)
+			}
+		})
+	}
+}
 
-	t.Run("nested but disallow password, disallow",
-		f(`{"password": "hacks"}`, no("password"), filter.WithNestedJSONB("meta", "created_at"), filter.WithDisallowColumns("password")))
+func TestConverter_ConvertUpdate_requiresWritableColumns(t *testing.T) {
+	c, err := filter.NewConverter(filter.WithAllowAllColumns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ConvertUpdate([]byte(`{"$set": {"name": "John"}}`), 1); err == nil || err.Error() != "ConvertUpdate requires the columns declared with WithWritableColumns" {
+		t.Errorf("Converter.ConvertUpdate() error = %v", err)
+	}
 }
 
-func TestConverter_ConvertOrderBy(t *testing.T) {
+func TestConverter_ConvertPipeline(t *testing.T) {
 	tests := []struct {
 		name     string
-		options  []filter.Option
-		input    string
-		expected string
+		pipeline string
+		query    string
+		values   []any
 		err      error
 	}{
 		{
-			"single field ascending",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"playerCount": 1}`,
-			`"playerCount" ASC NULLS LAST`,
+			"group",
+			`[{"$match": {"mode": "ranked"}}, {"$group": {"_id": "$map", "players": {"$avg": "$playerCount"}}}, {"$sort": {"players": -1}}, {"$limit": 10}]`,
+			`SELECT "meta"->>'map' AS "_id", avg(("meta"->>'playerCount')::numeric) AS "players" FROM "lobbies" WHERE ("meta"->>'mode' = $1) GROUP BY "meta"->>'map' ORDER BY "players" DESC NULLS LAST LIMIT $2`,
+			[]any{"ranked", int64(10)},
 			nil,
 		},
 		{
-			"single field descending",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"playerCount": -1}`,
-			`"playerCount" DESC NULLS LAST`,
+			"accumulators",
+			`[{"$group": {"_id": {"map": "$map", "level": "$level"}, "n": {"$sum": 1}, "total": {"$sum": "$playerCount"}, "names": {"$push": "$name"}, "pets": {"$push": "$pet"}, "lo": {"$min": "$level"}, "hi": {"$max": "$score"}, "c": {"$count": {}}, "x": {"$sum": 2}}}]`,
+			`SELECT "meta"->>'map' AS "map", "level" AS "level", count(*) AS "n", sum(("meta"->>'playerCount')::numeric) AS "total", array_agg("name") AS "names", jsonb_agg("meta"->'pet') AS "pets", min("level") AS "lo", max(("meta"->>'score')::numeric) AS "hi", count(*) AS "c", sum($1::numeric) AS "x" FROM "lobbies" GROUP BY "meta"->>'map', "level"`,
+			[]any{int64(2)},
 			nil,
 		},
 		{
-			"multiple fields",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"playerCount": -1, "name": 1}`,
-			`"playerCount" DESC NULLS LAST, "name" ASC NULLS LAST`,
+			"sum above 2^53",
+			`[{"$group": {"_id": null, "n": {"$sum": 9007199254740993}, "d": {"$sum": 0.1}}}]`,
+			`SELECT sum($1::numeric) AS "n", sum($2::numeric) AS "d" FROM "lobbies"`,
+			[]any{int64(9007199254740993), filter.Decimal("0.1")},
 			nil,
 		},
 		{
-			"nested JSONB single field ascending",
-			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
-			`{"map": 1}`,
-			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS LAST, "customdata"->>'map' ASC NULLS LAST`,
+			"group without _id",
+			`[{"$group": {"_id": null, "n": {"$sum": 1}}}]`,
+			`SELECT count(*) AS "n" FROM "lobbies"`,
+			nil,
 			nil,
 		},
 		{
-			"nested JSONB single field descending",
-			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
-			`{"map": -1}`,
-			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) DESC NULLS LAST, "customdata"->>'map' DESC NULLS LAST`,
+			"match after group",
+			`[{"$group": {"_id": "$map", "n": {"$sum": 1}}}, {"$match": {"n": {"$gt": 1}}}]`,
+			`SELECT * FROM (SELECT "meta"->>'map' AS "_id", count(*) AS "n" FROM "lobbies" GROUP BY "meta"->>'map') AS stage1 WHERE ("n" > $1)`,
+			[]any{int64(1)},
 			nil,
 		},
 		{
-			"nested JSONB multiple fields",
-			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
-			`{"map": 1, "bar": -1}`,
-			`(CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) ASC NULLS LAST, "customdata"->>'map' ASC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'bar') = 'number' THEN ("customdata"->>'bar')::numeric END) DESC NULLS LAST, "customdata"->>'bar' DESC NULLS LAST`,
+			"sort, skip, limit and project",
+			`[{"$sort": {"level": 1}}, {"$skip": 10}, {"$limit": 5}, {"$project": {"name": 1, "pet": 1}}]`,
+			`SELECT "name", "meta"->'pet' AS "pet" FROM "lobbies" ORDER BY "level" ASC NULLS LAST LIMIT $1 OFFSET $2`,
+			[]any{int64(5), int64(10)},
 			nil,
 		},
 		{
-			"mixed nested and regular fields",
-			[]filter.Option{filter.WithNestedJSONB("customdata", "created_at")},
-			`{"created_at": 1, "map": -1}`,
-			`"created_at" ASC NULLS LAST, (CASE WHEN jsonb_typeof("customdata"->'map') = 'number' THEN ("customdata"->>'map')::numeric END) DESC NULLS LAST, "customdata"->>'map' DESC NULLS LAST`,
+			"skip after limit",
+			`[{"$limit": 5}, {"$skip": 2}, {"$count": "total"}]`,
+			`SELECT count(*) AS "total" FROM (SELECT * FROM (SELECT * FROM "lobbies" LIMIT $1) AS stage1 OFFSET $2) AS stage2`,
+			[]any{int64(5), int64(2)},
 			nil,
 		},
 		{
-			"field name with spaces",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"my_field": 1}`,
-			`"my_field" ASC NULLS LAST`,
+			"count",
+			`[{"$match": {"level": {"$gt": 1}}}, {"$match": {}}, {"$count": "total"}]`,
+			`SELECT count(*) AS "total" FROM "lobbies" WHERE ("level" > $1)`,
+			[]any{int64(1)},
 			nil,
 		},
 		{
-			"empty object",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{}`,
+			"exclusion after group",
+			`[{"$group": {"_id": "$map", "n": {"$sum": 1}}}, {"$project": {"n": 0}}]`,
+			`SELECT "_id" FROM (SELECT "meta"->>'map' AS "_id", count(*) AS "n" FROM "lobbies" GROUP BY "meta"->>'map') AS stage1`,
+			nil,
+			nil,
+		},
+		{
+			"empty pipeline",
+			`[]`,
+			`SELECT * FROM "lobbies"`,
+			nil,
+			nil,
+		},
+		{
+			"match after project",
+			`[{"$project": {"name": 1}}, {"$match": {"name": "John"}}]`,
 			``,
 			nil,
+			fmt.Errorf("$match stage after $project not supported"),
 		},
 		{
-			"invalid field name for SQL injection",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"my field": 1}`,
+			"disallowed group key",
+			`[{"$group": {"_id": "$password"}}]`,
 			``,
-			fmt.Errorf("invalid column name: my field"),
+			nil,
+			filter.ColumnNotAllowedError{Column: "password"},
 		},
 		{
-			"invalid direction value",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"playerCount": 2}`,
+			"disallowed accumulator",
+			`[{"$group": {"_id": null, "n": {"$max": "$password"}}}]`,
 			``,
-			fmt.Errorf("invalid order direction for field playerCount: 2 (must be 1 or -1)"),
+			nil,
+			filter.ColumnNotAllowedError{Column: "password"},
 		},
 		{
-			"invalid direction string",
-			[]filter.Option{filter.WithAllowAllColumns()},
-			`{"playerCount": "asc"}`,
+			"field that isn't output by group",
+			`[{"$group": {"_id": "$map", "n": {"$sum": 1}}}, {"$sort": {"level": 1}}]`,
 			``,
-			fmt.Errorf("invalid order direction for field playerCount: asc (must be 1 or -1)"),
+			nil,
+			filter.ColumnNotAllowedError{Column: "level"},
 		},
 		{
-			"disallowed column",
-			[]filter.Option{filter.WithAllowColumns("name")},
-			`{"playerCount": 1}`,
+			"group without _id field",
+			`[{"$group": {"n": {"$sum": 1}}}]`,
 			``,
-			filter.ColumnNotAllowedError{Column: "playerCount"},
+			nil,
+			fmt.Errorf("$group stage requires an _id field"),
+		},
+		{
+			"unsupported accumulator",
+			`[{"$group": {"_id": null, "n": {"$first": "$name"}}}]`,
+			``,
+			nil,
+			fmt.Errorf("unsupported $group accumulator: $first"),
+		},
+		{
+			"unsupported stage",
+			`[{"$lookup": {"from": "players"}}]`,
+			``,
+			nil,
+			fmt.Errorf("unsupported pipeline stage: $lookup"),
+		},
+		{
+			"invalid limit",
+			`[{"$limit": -1}]`,
+			``,
+			nil,
+			fmt.Errorf("invalid value for $limit stage (must be a non-negative integer): -1"),
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			converter, err := filter.NewConverter(tt.options...)
+			c, err := filter.NewConverter(
+				filter.WithNestedJSONB("meta", "name", "level"),
+				filter.WithDisallowColumns("password"),
+				filter.WithTable("lobbies"),
+			)
 			if err != nil {
-				t.Fatalf("Failed to create converter: %v", err)
+				t.Fatal(err)
+			}
+			query, values, err := c.ConvertPipeline([]byte(tt.pipeline), 1)
+			if err != nil && (tt.err == nil || err.Error() != tt.err.Error()) {
+				t.Errorf("Converter.ConvertPipeline() error = %v, wantErr %v", err, tt.err)
+				return
 			}
+			if err == nil && tt.err != nil {
+				t.Errorf("Converter.ConvertPipeline() error = nil, wantErr %v", tt.err)
+				return
+			}
+			if query != tt.query {
+				t.Errorf("Converter.ConvertPipeline() query:\n%v\nwant:\n%v", query, tt.query)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("Converter.ConvertPipeline() values = %#v, want %#v", values, tt.values)
+			}
+		})
+	}
+}
 
-			result, err := converter.ConvertOrderBy([]byte(tt.input))
+func TestConverter_ConvertPipeline_maxLimit(t *testing.T) {
+	c, err := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithTable("lobbies"), filter.WithMaxLimit(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, values, err := c.ConvertPipeline([]byte(`[{"$match": {"map": "de_dust2"}}]`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `SELECT * FROM "lobbies" WHERE ("map" = $1) LIMIT $2`; query != want {
+		t.Errorf("Converter.ConvertPipeline() query = %v, want %v", query, want)
+	}
+	if want := []any{"de_dust2", int64(100)}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Converter.ConvertPipeline() values = %#v, want %#v", values, want)
+	}
+	if _, _, err := c.ConvertPipeline([]byte(`[{"$limit": 500}]`), 1); err == nil || err.Error() != "invalid value for $limit stage (must be at most 100): 500" {
+		t.Errorf("Converter.ConvertPipeline() error = %v", err)
+	}
+}
 
-			if tt.err != nil {
-				if err == nil {
-					t.Fatalf("Expected error %v, got nil", tt.err)
-				}
-				if err.Error() != tt.err.Error() {
-					t.Errorf("Expected error %v, got %v", tt.err, err)
+func TestConverter_ParseConditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"comparisons",
+			`{"level": {"$gt": 10, "$lte": 20}, "name": "Alice", "pet": {"$ne": "cat"}}`,
+			`{"level":{"$gt":10,"$lte":20},"name":"Alice","pet":{"$ne":"cat"}}`,
+		},
+		{
+			"nested numbers and booleans",
+			`{"guild_id": 20, "active": true, "score": {"$gte": 1.5}}`,
+			`{"active":true,"guild_id":20,"score":{"$gte":{"$numberDecimal":"1.5"}}}`,
+		},
+		{
+			"in and nin",
+			`{"pet": {"$in": ["cat", "dog"]}, "level": {"$nin": [1, 2]}}`,
+			`{"level":{"$nin":[1,2]},"pet":{"$in":["cat","dog"]}}`,
+		},
+		{
+			"exists and null",
+			`{"mount": {"$exists": false}, "pet": null, "name": null}`,
+			`{"mount":{"$exists":false},"name":null,"pet":null}`,
+		},
+		{
+			"or, nor and not",
+			`{"$or": [{"level": 1}, {"pet": "cat"}], "$nor": [{"name": {"$regex": "^bot"}}], "$not": {"guild_id": {"$lt": 10}}}`,
+			`{"$nor":[{"name":{"$regex":"^bot"}}],"$not":{"guild_id":{"$lt":10}},"$or":[{"level":1},{"pet":"cat"}]}`,
+		},
+		{
+			"and on the same field",
+			`{"$and": [{"level": {"$gt": 10}}, {"level": {"$gt": 5}}]}`,
+			`{"$and":[{"level":{"$gt":10}},{"level":{"$gt":5}}]}`,
+		},
+		{
+			"regular expression literal",
+			`{"name": {"$not": "/^bot/"}}`,
+			`{"name":{"$not":"/^bot/"}}`,
+		},
+		{
+			"elemMatch",
+			`{"items": {"$elemMatch": {"$gt": 3, "$lt": 6}}, "tags": {"$elemMatch": {"$in": ["a", "b"]}}}`,
+			`{"items":{"$elemMatch":{"$gt":3,"$lt":6}},"tags":{"$elemMatch":{"$in":["a","b"]}}}`,
+		},
+		{
+			"elemMatch with dates",
+			`{"logins": {"$elemMatch": {"$gte": {"$date": "2024-01-02T03:04:05Z"}}}}`,
+			`{"logins":{"$elemMatch":{"$gte":{"$date":"2024-01-02T03:04:05Z"}}}}`,
+		},
+		{
+			"field comparisons",
+			`{"level": {"$field": "guild_id"}, "score": {"$gt": {"$field": "level"}}}`,
+			`{"level":{"$eq":{"$field":"guild_id"}},"score":{"$gt":{"$field":"level"}}}`,
+		},
+		{
+			"composite values",
+			`{"position": {"x": 1, "y": 2}, "tags": ["a", "b"], "keys": {"$ne": [1, 3]}}`,
+			`{"keys":{"$ne":[1,3]},"position":{"x":1,"y":2},"tags":["a","b"]}`,
+		},
+		{
+			"extended JSON",
+			`{"created": {"$gt": {"$date": "2024-01-02T03:04:05Z"}}, "id": {"$uuid": "123e4567-e89b-12d3-a456-426614174000"}}`,
+			`{"created":{"$gt":{"$date":"2024-01-02T03:04:05Z"}},"id":{"$eq":{"$uuid":"123e4567-e89b-12d3-a456-426614174000"}}}`,
+		},
+		{
+			"empty filter",
+			`{}`,
+			`{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := filter.NewConverter(filter.WithNestedJSONB("meta", "level", "name", "tags"), filter.WithArrayDriver(testArrayDriver))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conditions, values, err := c.Convert([]byte(tt.input), 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// ParseConditions needs the values without the array driver.
+			unwrapped := make([]any, len(values))
+			for i, v := range values {
+				if a, ok := v.(testArray); ok {
+					v = a.a
 				}
-				return
+				unwrapped[i] = v
+			}
+			parsed, err := c.ParseConditions(conditions, unwrapped, 3)
+			if err != nil {
+				t.Fatalf("Converter.ParseConditions(%s) error = %v", conditions, err)
+			}
+			if string(parsed) != tt.expected {
+				t.Errorf("Converter.ParseConditions() = %s, want %s", parsed, tt.expected)
+			}
+			again, againValues, err := c.Convert(parsed, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != conditions || !reflect.DeepEqual(againValues, values) {
+				t.Errorf("Converter.Convert() of parsed filter = %s %#v, want %s %#v", again, againValues, conditions, values)
 			}
+		})
+	}
+}
+
+func TestConverter_ParseConditions_earlierVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions string
+		values     []any
+		expected   string
+	}{
+		{
+			"$exists",
+			`(jsonb_path_match(meta, 'exists($.pet)'))`,
+			nil,
+			`{"pet":{"$exists":true}}`,
+		},
+		{
+			"not $exists",
+			`(("level" > $1) AND (NOT jsonb_path_match(meta, 'exists($.pet)')))`,
+			[]any{int64(10)},
+			`{"level":{"$gt":10},"pet":{"$exists":false}}`,
+		},
+		{
+			"null",
+			`(jsonb_path_match(meta, 'exists($.pet)') AND "meta"->>'pet' IS NULL)`,
+			nil,
+			`{"pet":null}`,
+		},
+	}
 
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := filter.NewConverter(filter.WithNestedJSONB("meta", "level", "name"))
 			if err != nil {
-				t.Fatalf("Unexpected error: %v", err)
+				t.Fatal(err)
 			}
+			parsed, err := c.ParseConditions(tt.conditions, tt.values, 1)
+			if err != nil {
+				t.Fatalf("Converter.ParseConditions(%s) error = %v", tt.conditions, err)
+			}
+			if string(parsed) != tt.expected {
+				t.Errorf("Converter.ParseConditions() = %s, want %s", parsed, tt.expected)
+			}
+		})
+	}
+}
 
-			if result != tt.expected {
-				t.Errorf("Expected %q, got %q", tt.expected, result)
+func TestConverter_ParseConditions_errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []filter.Option
+		conditions string
+		values     []any
+		err        error
+	}{
+		{
+			"unsupported option",
+			[]filter.Option{filter.WithAllowAllColumns(), filter.WithJSONPath()},
+			`("level" = $1)`,
+			[]any{int64(1)},
+			fmt.Errorf("ParseConditions doesn't support WithJSONPath"),
+		},
+		{
+			"unsupported SQL",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`("level" = $1 AND "name" = $2 OR "pet" = $3)`,
+			[]any{int64(1), "a", "b"},
+			fmt.Errorf(`unsupported SQL at position 33: "pet" = $3)`),
+		},
+		{
+			"missing value",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`("level" = $2)`,
+			[]any{int64(1)},
+			fmt.Errorf("no value for parameter $2"),
+		},
+		{
+			"column not allowed",
+			[]filter.Option{filter.WithAllowColumns("level")},
+			`("name" = $1)`,
+			[]any{"a"},
+			filter.ColumnNotAllowedError{Column: "name"},
+		},
+		{
+			"case sensitive regular expression",
+			[]filter.Option{filter.WithAllowAllColumns()},
+			`("name" ~ $1)`,
+			[]any{"^bot"},
+			fmt.Errorf("unsupported operator: ~"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := filter.NewConverter(tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.ParseConditions(tt.conditions, tt.values, 1); err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("Converter.ParseConditions() error = %v, want %v", err, tt.err)
 			}
 		})
 	}
 }
+
+// TestConverter_ParseConditions_roundTrip checks that random filters convert to the same
+// conditions and values after parsing their conditions.
+func TestConverter_ParseConditions_roundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pick := func(options ...any) any {
+		return options[rng.Intn(len(options))]
+	}
+	scalar := func() any {
+		return pick(
+			rng.Intn(100),
+			fmt.Sprintf("%.1f", rng.Float64()*10),
+			pick("cat", "dog", "Alice"),
+			rng.Intn(2) == 0,
+			map[string]any{"$date": time.Date(2024, 1, rng.Intn(28)+1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)},
+			map[string]any{"$uuid": "123e4567-e89b-12d3-a456-42661417400" + fmt.Sprint(rng.Intn(10))},
+		)
+	}
+	var operators func(field string, depth int) map[string]any
+	operators = func(field string, depth int) map[string]any {
+		ops := map[string]any{}
+		for i := rng.Intn(2); i >= 0; i-- {
+			switch rng.Intn(9) {
+			case 0:
+				ops[pick("$eq", "$ne").(string)] = scalar()
+			case 1:
+				ops[pick("$gt", "$gte", "$lt", "$lte").(string)] = pick(rng.Intn(100), "b")
+			case 2:
+				ops[pick("$in", "$nin").(string)] = []any{pick("cat", 1), pick("dog", 2)}
+			case 3:
+				ops["$regex"] = pick("^bot", "a.c")
+			case 4:
+				if field != "level" && field != "name" {
+					ops["$exists"] = rng.Intn(2) == 0
+				}
+			case 5:
+				if rng.Intn(3) == 0 {
+					ops["$field"] = pick("level", "guild_id", "created")
+				} else {
+					ops[pick("$eq", "$gt").(string)] = map[string]any{"$field": pick("level", "guild_id", "created")}
+				}
+			case 6:
+				if depth > 0 {
+					ops["$not"] = pick("/^bot/", "/x/i", operators(field, depth-1))
+				}
+			case 7:
+				if depth > 0 && field != "level" && field != "name" {
+					ops["$elemMatch"] = operators(field, depth-1)
+				}
+			case 8:
+				ops["$eq"] = pick([]any{1, "a"}, map[string]any{"x": 1})
+			}
+		}
+		if len(ops) == 0 {
+			ops["$ne"] = scalar()
+		}
+		return ops
+	}
+	var query func(depth int) map[string]any
+	query = func(depth int) map[string]any {
+		q := map[string]any{}
+		for i := rng.Intn(3); i >= 0; i-- {
+			field := pick("level", "name", "pet", "guild_id", "items", "created").(string)
+			switch n := rng.Intn(8); {
+			case n < 3:
+				q[field] = operators(field, 2)
+			case n == 3:
+				q[field] = scalar()
+			case n == 4:
+				q[field] = nil
+			case depth > 0 && n == 5:
+				q[pick("$and", "$or", "$nor").(string)] = []any{query(depth - 1), query(depth - 1)}
+			case depth > 0 && n == 6:
+				q["$not"] = query(depth - 1)
+			default:
+				q[field] = map[string]any{"$in": []any{scalar()}}
+			}
+		}
+		return q
+	}
+
+	c, err := filter.NewConverter(filter.WithNestedJSONB("meta", "level", "name"), filter.WithFieldTypes(map[string]filter.FieldType{"created": filter.FieldTypeTimestamp}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		input, err := json.Marshal(query(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		startAt := rng.Intn(3) + 1
+		conditions, values, err := c.Convert(input, startAt)
+		if err != nil {
+			// Some random filters are invalid, like embedded documents compared with regular columns.
+			continue
+		}
+		parsed, err := c.ParseConditions(conditions, values, startAt)
+		if err != nil {
+			t.Fatalf("Converter.ParseConditions() of %s: %s error = %v", input, conditions, err)
+		}
+		again, againValues, err := c.Convert(parsed, startAt)
+		if err != nil {
+			t.Fatalf("Converter.Convert() of %s parsed from %s error = %v", parsed, input, err)
+		}
+		if again != conditions || !reflect.DeepEqual(againValues, values) {
+			t.Fatalf("round trip of %s:\n%s %#v\nparsed as %s:\n%s %#v", input, conditions, values, parsed, again, againValues)
+		}
+	}
+}
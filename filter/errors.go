@@ -5,6 +5,9 @@ import "fmt"
 // ErrNoAccessOption is returned when no access options are provided to NewConverter.
 var ErrNoAccessOption = fmt.Errorf("NewConverter: need atleast one of the access options: WithAllowAllColumns, WithAllowColumns, WithNestedJSONB")
 
+// ErrInvalidCursor is returned by DecodeCursor when a cursor is malformed or has an invalid signature.
+var ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
 type ColumnNotAllowedError struct {
 	Column string
 }
@@ -13,11 +16,22 @@ func (e ColumnNotAllowedError) Error() string {
 	return fmt.Sprintf("column not allowed: %s", e.Column)
 }
 
+// InvalidOrderDirectionError is returned for sort directions that aren't 1, -1, "asc", "desc",
+// "ascending" or "descending".
 type InvalidOrderDirectionError struct {
 	Field string
 	Value any
 }
 
 func (e InvalidOrderDirectionError) Error() string {
-	return fmt.Sprintf("invalid order direction for field %s: %v (must be 1 or -1)", e.Field, e.Value)
+	return fmt.Sprintf("invalid order direction for field %s: %v (must be 1, -1, \"asc\" or \"desc\")", e.Field, e.Value)
+}
+
+// ColumnNotWritableError is returned by ConvertUpdate for columns that aren't declared with WithWritableColumns.
+type ColumnNotWritableError struct {
+	Column string
+}
+
+func (e ColumnNotWritableError) Error() string {
+	return fmt.Sprintf("column not writable: %s", e.Column)
 }
@@ -0,0 +1,354 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// exprComparisonMap contains the comparison operators that can be used in $expr.
+var exprComparisonMap = map[string]string{
+	"$eq":  "=",
+	"$ne":  "!=",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// exprArithmeticMap contains the arithmetic operators that can be used in $expr, with the
+// number of arguments they take (-1 for one or more).
+var exprArithmeticMap = map[string]struct {
+	op    string
+	nargs int
+}{
+	"$add":      {"+", -1},
+	"$subtract": {"-", 2},
+	"$multiply": {"*", -1},
+	"$divide":   {"/", 2},
+}
+
+// exprValue is a converted $expr operand.
+type exprValue struct {
+	// sql is the SQL of a computed value, it's empty for fields and literals.
+	sql string
+	// cast is the type of the value (see jsonbCast), or an empty string if it's unknown.
+	cast string
+
+	column    string
+	literal   any
+	isLiteral bool
+}
+
+// convertExpr converts the $expr operator, for example:
+//
+//	{"$expr": {"$lt": [{"$add": ["$playerCount", 2]}, "$maxPlayers"]}}
+//
+// becomes:
+//
+//	((("playerCount" + $1::numeric)) < "maxPlayers")
+//
+// Only a whitelist of operators is supported, every field is checked with isColumnAllowed
+// and all literals are bound as values.
+func (c *Converter) convertExpr(value any, paramIndex int) (string, []any, error) {
+	p := &boundValues{paramIndex: paramIndex}
+	condition, err := c.exprCondition(value, p)
+	if err != nil {
+		return "", nil, err
+	}
+	return "(" + condition + ")", p.values, nil
+}
+
+// exprCondition converts an $expr expression that results in a boolean: a comparison, $and or $or.
+func (c *Converter) exprCondition(value any, p *boundValues) (string, error) {
+	operator, args, err := exprOperator(value)
+	if err != nil {
+		return "", err
+	}
+	switch operator {
+	case "$and", "$or":
+		list, ok := args.([]any)
+		if !ok || len(list) == 0 {
+			return "", fmt.Errorf("invalid value for %s operator (must be array of expressions): %v", operator, args)
+		}
+		conditions := make([]string, 0, len(list))
+		for _, e := range list {
+			condition, err := c.exprCondition(e, p)
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, condition)
+		}
+		if operator == "$and" {
+			return "(" + strings.Join(conditions, " AND ") + ")", nil
+		}
+		return "(" + strings.Join(conditions, " OR ") + ")", nil
+	}
+
+	op, ok := exprComparisonMap[operator]
+	if !ok {
+		return "", fmt.Errorf("unsupported $expr operator (must be a comparison, $and or $or): %s", operator)
+	}
+	operands, err := c.exprOperands(operator, args, 2, p)
+	if err != nil {
+		return "", err
+	}
+	left, right := operands[0], operands[1]
+
+	// Comparing with null needs IS NULL, as = NULL is never true.
+	if left.isLiteral && left.literal == nil {
+		left, right = right, left
+	}
+	if right.isLiteral && right.literal == nil {
+		if op != "=" && op != "!=" {
+			return "", fmt.Errorf("invalid value for %s operator (null can only be compared with $eq and $ne): %v", operator, args)
+		}
+		sql, err := c.exprSQL(left, "", p)
+		if err != nil {
+			return "", err
+		}
+		if op == "=" {
+			return fmt.Sprintf("(%s IS NULL)", sql), nil
+		}
+		return fmt.Sprintf("(%s IS NOT NULL)", sql), nil
+	}
+
+	if left, err = c.exprFieldValue(left, right); err != nil {
+		return "", err
+	}
+	if right, err = c.exprFieldValue(right, left); err != nil {
+		return "", err
+	}
+
+	cast := left.cast
+	if cast == "" {
+		cast = right.cast
+	} else if right.cast != "" && right.cast != cast {
+		return "", fmt.Errorf("invalid value for %s operator (can't compare %s with %s): %v", operator, left.cast, right.cast, args)
+	}
+	if cast == "" && (c.fieldTypes[left.column] == FieldTypeTimestamp || c.fieldTypes[right.column] == FieldTypeTimestamp) {
+		cast = "timestamptz"
+	} else if cast == "" && op != "=" && op != "!=" {
+		// Just like $field, fields compared with > and < are compared as numbers.
+		cast = "numeric"
+	}
+
+	left, right = untypedString(left, right), untypedString(right, left)
+
+	leftSQL, err := c.exprSQL(left, cast, p)
+	if err != nil {
+		return "", err
+	}
+	rightSQL, err := c.exprSQL(right, cast, p)
+	if err != nil {
+		return "", err
+	}
+	// With WithMongoNullSemantics $ne also matches fields that are NULL, and $eq matches two NULL fields.
+	return fmt.Sprintf("(%s %s %s)", leftSQL, c.nullSafeOperator(op, nil), rightSQL), nil
+}
+
+// exprFieldValue converts v to the type declared with WithFieldTypes if it's a literal compared
+// with the field other, so {"$gt": ["$last_seen", "2024-01-01"]} compares timestamps.
+func (c *Converter) exprFieldValue(v, other exprValue) (exprValue, error) {
+	if !v.isLiteral || other.column == "" {
+		return v, nil
+	}
+	value, err := c.fieldValue(other.column, v.literal)
+	if err != nil {
+		return exprValue{}, err
+	}
+	return exprLiteral(value), nil
+}
+
+// exprLiteral returns the operand of a literal value.
+func exprLiteral(v any) exprValue {
+	if _, ok := v.(string); ok {
+		return exprValue{literal: v, isLiteral: true, cast: "text"}
+	}
+	return exprValue{literal: v, isLiteral: true, cast: jsonbCast(v)}
+}
+
+// untypedString removes the text cast of v if it's a string literal compared with a field or an
+// expression, so Postgres infers its type and it can be compared with columns like enums or UUIDs.
+func untypedString(v, other exprValue) exprValue {
+	if v.isLiteral && v.cast == "text" && !other.isLiteral {
+		v.cast = ""
+	}
+	return v
+}
+
+// exprOperand converts an $expr operand: a field reference like "$playerCount", a literal or
+// an object with an arithmetic or string operator.
+func (c *Converter) exprOperand(value any, p *boundValues) (exprValue, error) {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return exprValue{}, err
+	}
+	switch v := value.(type) {
+	case string:
+		if !strings.HasPrefix(v, "$") {
+			return exprLiteral(v), nil
+		}
+		column := v[1:]
+		if !isValidPostgresIdentifier(column) {
+			return exprValue{}, fmt.Errorf("invalid column name: %s", column)
+		}
+		if !c.isColumnAllowed(column) {
+			return exprValue{}, ColumnNotAllowedError{Column: column}
+		}
+		return exprValue{column: column}, nil
+	case map[string]any:
+	case []any:
+		return exprValue{}, fmt.Errorf("invalid $expr operand (arrays are not supported): %v", value)
+	default:
+		return exprLiteral(v), nil
+	}
+
+	operator, args, err := exprOperator(value)
+	if err != nil {
+		return exprValue{}, err
+	}
+	if arithmetic, ok := exprArithmeticMap[operator]; ok {
+		operands, err := c.exprOperands(operator, args, arithmetic.nargs, p)
+		if err != nil {
+			return exprValue{}, err
+		}
+		terms := make([]string, 0, len(operands))
+		for _, operand := range operands {
+			if operand.cast != "" && operand.cast != "numeric" {
+				return exprValue{}, fmt.Errorf("invalid value for %s operator (must be numbers): %v", operator, args)
+			}
+			sql, err := c.exprSQL(operand, "numeric", p)
+			if err != nil {
+				return exprValue{}, err
+			}
+			terms = append(terms, sql)
+		}
+		if operator == "$divide" {
+			// Postgres does integer division on integer columns, and fails the whole query when dividing by zero.
+			return exprValue{sql: fmt.Sprintf("(%s / NULLIF(%s, 0)::numeric)", terms[0], terms[1]), cast: "numeric"}, nil
+		}
+		return exprValue{sql: "(" + strings.Join(terms, " "+arithmetic.op+" ") + ")", cast: "numeric"}, nil
+	}
+
+	switch operator {
+	case "$abs":
+		operands, err := c.exprOperands(operator, args, 1, p)
+		if err != nil {
+			return exprValue{}, err
+		}
+		if operands[0].cast != "" && operands[0].cast != "numeric" {
+			return exprValue{}, fmt.Errorf("invalid value for $abs operator (must be a number): %v", args)
+		}
+		sql, err := c.exprSQL(operands[0], "numeric", p)
+		if err != nil {
+			return exprValue{}, err
+		}
+		return exprValue{sql: fmt.Sprintf("abs(%s)", sql), cast: "numeric"}, nil
+	case "$concat", "$toLower":
+		nargs := -1
+		if operator == "$toLower" {
+			nargs = 1
+		}
+		operands, err := c.exprOperands(operator, args, nargs, p)
+		if err != nil {
+			return exprValue{}, err
+		}
+		texts := make([]string, 0, len(operands))
+		for _, operand := range operands {
+			if operand.cast != "" && operand.cast != "text" {
+				return exprValue{}, fmt.Errorf("invalid value for %s operator (must be strings): %v", operator, args)
+			}
+			sql, err := c.exprSQL(operand, "text", p)
+			if err != nil {
+				return exprValue{}, err
+			}
+			if operand.column != "" && !c.isNestedColumn(operand.column) {
+				sql += "::text"
+			}
+			texts = append(texts, sql)
+		}
+		if operator == "$toLower" {
+			return exprValue{sql: fmt.Sprintf("lower(%s)", texts[0]), cast: "text"}, nil
+		}
+		return exprValue{sql: "(" + strings.Join(texts, " || ") + ")", cast: "text"}, nil
+	case "$size":
+		operands, err := c.exprOperands(operator, args, 1, p)
+		if err != nil {
+			return exprValue{}, err
+		}
+		column := operands[0].column
+		if column == "" {
+			return exprValue{}, fmt.Errorf("invalid value for $size operator (must be a field): %v", args)
+		}
+		if c.isNestedColumn(column) {
+			// jsonb_array_length fails on values that aren't arrays, those get NULL instead.
+			jsonb := c.columnName(column, false)
+			return exprValue{sql: fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'array' THEN jsonb_array_length(%s) END)", jsonb, jsonb), cast: "numeric"}, nil
+		}
+		return exprValue{sql: fmt.Sprintf("cardinality(%s)", c.columnName(column, true)), cast: "numeric"}, nil
+	default:
+		return exprValue{}, fmt.Errorf("unsupported $expr operator: %s", operator)
+	}
+}
+
+// exprOperands converts the arguments of operator, which must be an array of nargs operands
+// (or one or more if nargs is -1). Operators with one argument can also get the operand itself.
+func (c *Converter) exprOperands(operator string, args any, nargs int, p *boundValues) ([]exprValue, error) {
+	list, ok := args.([]any)
+	if !ok && nargs == 1 {
+		list, ok = []any{args}, true
+	}
+	if !ok || (nargs == -1 && len(list) == 0) || (nargs != -1 && len(list) != nargs) {
+		if nargs == -1 {
+			return nil, fmt.Errorf("invalid value for %s operator (must be array of expressions): %v", operator, args)
+		}
+		return nil, fmt.Errorf("invalid value for %s operator (must be array of %d expressions): %v", operator, nargs, args)
+	}
+	operands := make([]exprValue, 0, len(list))
+	for _, e := range list {
+		operand, err := c.exprOperand(e, p)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	return operands, nil
+}
+
+// exprSQL returns the SQL of v used as a value of type cast. Fields in the nested JSONB column
+// are cast to it, and literals are bound with it.
+func (c *Converter) exprSQL(v exprValue, cast string, p *boundValues) (string, error) {
+	switch {
+	case v.isLiteral:
+		if v.literal == nil {
+			return "", fmt.Errorf("invalid $expr operand (null can only be compared with $eq and $ne)")
+		}
+		// Literals are cast, Postgres can't infer the type of an expression like $1 + $2 or $1 || $2.
+		if v.cast == "" {
+			return p.add(v.literal), nil
+		}
+		return fmt.Sprintf("%s::%s", p.add(v.literal), v.cast), nil
+	case v.column != "":
+		if !c.isNestedColumn(v.column) {
+			return c.columnName(v.column, true), nil
+		}
+		if cast == "" || cast == "text" {
+			return c.columnName(v.column, true), nil
+		}
+		return c.castColumn(v.column, cast), nil
+	default:
+		return v.sql, nil
+	}
+}
+
+// exprOperator returns the operator of an $expr object and its arguments.
+func exprOperator(value any) (string, any, error) {
+	v, ok := value.(map[string]any)
+	if !ok || len(v) != 1 {
+		return "", nil, fmt.Errorf("invalid $expr expression (must be object with one operator): %v", value)
+	}
+	for operator, args := range v {
+		return operator, args, nil
+	}
+	return "", nil, nil
+}
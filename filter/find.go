@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FindQuery is a MongoDB find command converted with [Converter.ConvertFind]. It can be
+// turned into a query like:
+//
+//	"SELECT " + f.Select + " FROM lobbies WHERE " + f.Conditions + " ORDER BY " + f.OrderBy + " " + f.LimitOffset
+//
+// OrderBy and LimitOffset are empty if the find command doesn't need them.
+type FindQuery struct {
+	// Select is the list of columns of the projection, or * without a projection.
+	Select string
+	// Conditions are the conditions of the filter, see [Converter.Convert].
+	Conditions string
+	// OrderBy is the ORDER BY clause of the sort object, see [Converter.ConvertOrderBy].
+	OrderBy string
+	// LimitOffset is the LIMIT and OFFSET clause, for example: LIMIT $3 OFFSET $4
+	LimitOffset string
+	// Values contains the values of Conditions, OrderBy and LimitOffset.
+	Values []any
+}
+
+// ConvertFind converts a MongoDB find command like:
+//
+//	{"filter": {"map": "de_dust2"}, "sort": {"playerCount": -1}, "limit": 50, "skip": 100, "projection": {"name": 1}}
+//
+// into a [FindQuery]. All fields are optional, a missing filter results in the empty
+// condition (see [WithEmptyCondition]). The limit can't be higher than the maximum set
+// with [WithMaxLimit], which is also used when there is no limit.
+func (c *Converter) ConvertFind(query []byte, startAtParameterIndex int) (FindQuery, error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return FindQuery{}, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+
+	var find map[string]json.RawMessage
+	if err := decodeJSON(bytes.NewReader(query), &find); err != nil {
+		return FindQuery{}, err
+	}
+	for key, value := range find {
+		switch key {
+		case "filter", "sort", "limit", "skip", "projection":
+			if bytes.Equal(value, []byte("null")) {
+				delete(find, key)
+			}
+		default:
+			return FindQuery{}, fmt.Errorf("unknown find field: %s", key)
+		}
+	}
+
+	var mongoFilter map[string]any
+	if find["filter"] != nil {
+		if err := decodeJSON(bytes.NewReader(find["filter"]), &mongoFilter); err != nil {
+			return FindQuery{}, fmt.Errorf("invalid value for filter (must be object): %w", err)
+		}
+	}
+	conditions, values, err := c.ConvertMap(mongoFilter, startAtParameterIndex)
+	if err != nil {
+		return FindQuery{}, err
+	}
+	paramIndex := startAtParameterIndex + len(values)
+
+	var orderBy string
+	if find["sort"] != nil {
+		var text *textSearch
+		if value, ok := mongoFilter["$text"]; ok {
+			t, err := c.parseTextSearch(value)
+			if err != nil {
+				return FindQuery{}, err
+			}
+			text = &t
+		}
+		var orderByValues []any
+		orderBy, orderByValues, err = c.convertOrderBy(find["sort"], text, paramIndex)
+		if err != nil {
+			return FindQuery{}, err
+		}
+		paramIndex += len(orderByValues)
+		values = append(values, orderByValues...)
+	}
+
+	limit, err := findNumber(find, "limit")
+	if err != nil {
+		return FindQuery{}, err
+	}
+	if c.maxLimit > 0 && limit > c.maxLimit {
+		return FindQuery{}, fmt.Errorf("invalid value for limit (must be at most %d): %d", c.maxLimit, limit)
+	}
+	if limit == 0 {
+		// Like in MongoDB a limit of 0 means no limit.
+		limit = c.maxLimit
+	}
+	skip, err := findNumber(find, "skip")
+	if err != nil {
+		return FindQuery{}, err
+	}
+	var limitOffset []string
+	if limit > 0 {
+		limitOffset = append(limitOffset, fmt.Sprintf("LIMIT $%d", paramIndex))
+		paramIndex++
+		values = append(values, limit)
+	}
+	if skip > 0 {
+		limitOffset = append(limitOffset, fmt.Sprintf("OFFSET $%d", paramIndex))
+		values = append(values, skip)
+	}
+
+	selectList := "*"
+	if find["projection"] != nil {
+		if selectList, err = c.convertProjection(find["projection"]); err != nil {
+			return FindQuery{}, err
+		}
+	}
+
+	return FindQuery{
+		Select:      selectList,
+		Conditions:  conditions,
+		OrderBy:     orderBy,
+		LimitOffset: strings.Join(limitOffset, " "),
+		Values:      values,
+	}, nil
+}
+
+// findNumber returns the non-negative integer field key of a find command, or 0 if it's missing.
+func findNumber(find map[string]json.RawMessage, key string) (int64, error) {
+	if find[key] == nil {
+		return 0, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(find[key], &n); err != nil {
+		return 0, fmt.Errorf("invalid value for %s (must be a non-negative integer): %s", key, find[key])
+	}
+	i, err := n.Int64()
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value for %s (must be a non-negative integer): %s", key, find[key])
+	}
+	return i, nil
+}
@@ -0,0 +1,350 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadius is the radius of the earth in meters, which MongoDB uses to convert radians to distances.
+const earthRadius = 6378100
+
+func isGeoType(t FieldType) bool {
+	return t == FieldTypeGeometry || t == FieldTypeGeography || t == FieldTypePoint
+}
+
+// geoColumnType returns the declared type of column, which must be a geometry, geography
+// or point column to use the geospatial operators.
+func (c *Converter) geoColumnType(column, operator string) (FieldType, error) {
+	t := c.fieldTypes[column]
+	if !isGeoType(t) || c.isNestedColumn(column) {
+		return "", fmt.Errorf("%s operator requires a geometry, geography or point column: %s", operator, column)
+	}
+	return t, nil
+}
+
+// geoCondition converts the geospatial operators $near, $nearSphere, $geoWithin and $geoIntersects.
+// v contains all operators of the field, as $near can have $maxDistance and $minDistance next to it.
+func (c *Converter) geoCondition(column, operator string, v map[string]any, paramIndex int) (string, []any, error) {
+	t, err := c.geoColumnType(column, operator)
+	if err != nil {
+		return "", nil, err
+	}
+	p := &boundValues{paramIndex: paramIndex}
+
+	var condition string
+	switch operator {
+	case "$near", "$nearSphere":
+		condition, err = c.geoNear(column, t, operator, v, p)
+	case "$geoWithin":
+		condition, err = c.geoWithin(column, t, v[operator], p)
+	case "$geoIntersects":
+		condition, err = c.geoIntersects(column, t, v[operator], p)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return "(" + condition + ")", p.values, nil
+}
+
+// geoNear converts $near and $nearSphere, for example:
+//
+//	{"$near": {"$geometry": {"type": "Point", "coordinates": [4.9, 52.4]}, "$maxDistance": 1000}}
+//	{"$near": [4.9, 52.4], "$maxDistance": 1000}
+//
+// Distances are in meters for geometry and geography columns, and in the units of the
+// coordinates for point columns.
+func (c *Converter) geoNear(column string, t FieldType, operator string, v map[string]any, p *boundValues) (string, error) {
+	near := v[operator]
+	maxDistance, minDistance := v["$maxDistance"], v["$minDistance"]
+	if n, ok := near.(map[string]any); ok && n["$geometry"] != nil {
+		for key := range n {
+			if key != "$geometry" && key != "$maxDistance" && key != "$minDistance" {
+				return "", fmt.Errorf("unknown %s option: %s", operator, key)
+			}
+		}
+		near = n["$geometry"]
+		if d, ok := n["$maxDistance"]; ok {
+			maxDistance = d
+		}
+		if d, ok := n["$minDistance"]; ok {
+			minDistance = d
+		}
+	}
+	if t == FieldTypePoint && operator == "$nearSphere" {
+		return "", fmt.Errorf("$nearSphere operator not supported on point columns")
+	}
+	x, y, err := geoPoint(near)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for %s operator: %w", operator, err)
+	}
+
+	if maxDistance == nil && minDistance == nil {
+		// Without a distance $near matches everything with a location, use ConvertOrderBy to sort on the distance.
+		return fmt.Sprintf("%q IS NOT NULL", column), nil
+	}
+
+	// within and distance return the conditions for a maximum and minimum distance.
+	var within, distance func(d string) string
+	if t == FieldTypePoint {
+		point := fmt.Sprintf("point(%s, %s)", p.add(x), p.add(y))
+		within = func(d string) string { return fmt.Sprintf("(%q <-> %s) <= %s", column, point, d) }
+		distance = func(d string) string { return fmt.Sprintf("(%q <-> %s) >= %s", column, point, d) }
+	} else {
+		point := postGISPoint(p.add(x), p.add(y)) + "::geography"
+		// ST_DWithin can use an index, unlike ST_Distance.
+		within = func(d string) string { return fmt.Sprintf("ST_DWithin(%s, %s, %s)", geography(column, t), point, d) }
+		distance = func(d string) string { return fmt.Sprintf("ST_Distance(%s, %s) >= %s", geography(column, t), point, d) }
+	}
+
+	conditions := []string{}
+	if maxDistance != nil {
+		d, err := geoNumber(maxDistance)
+		if err != nil || d < 0 {
+			return "", fmt.Errorf("invalid value for $maxDistance: %v", maxDistance)
+		}
+		conditions = append(conditions, within(p.add(d)))
+	}
+	if minDistance != nil {
+		d, err := geoNumber(minDistance)
+		if err != nil || d < 0 {
+			return "", fmt.Errorf("invalid value for $minDistance: %v", minDistance)
+		}
+		conditions = append(conditions, distance(p.add(d)))
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// geoWithin converts $geoWithin with one of the shapes $geometry, $box, $polygon, $center
+// or $centerSphere.
+func (c *Converter) geoWithin(column string, t FieldType, value any, p *boundValues) (string, error) {
+	v, ok := value.(map[string]any)
+	if !ok || len(v) != 1 {
+		return "", fmt.Errorf("invalid value for $geoWithin operator (must be object with one shape): %v", value)
+	}
+	for shape, coordinates := range v {
+		switch shape {
+		case "$geometry":
+			if t == FieldTypePoint {
+				ring, err := geoJSONPolygonRing(coordinates)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%q <@ %s::polygon", column, p.add(polygonText(ring))), nil
+			}
+			geometry, err := geoJSON(coordinates)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("ST_CoveredBy(%q, %s)", column, postGISShape(t, "ST_GeomFromGeoJSON("+p.add(geometry)+")")), nil
+		case "$box":
+			corners, err := geoPoints(coordinates)
+			if err != nil || len(corners) != 2 {
+				return "", fmt.Errorf("invalid value for $box (must be two points): %v", coordinates)
+			}
+			x1, y1, x2, y2 := p.add(corners[0][0]), p.add(corners[0][1]), p.add(corners[1][0]), p.add(corners[1][1])
+			if t == FieldTypePoint {
+				return fmt.Sprintf("%q <@ box(point(%s, %s), point(%s, %s))", column, x1, y1, x2, y2), nil
+			}
+			return fmt.Sprintf("ST_CoveredBy(%q, %s)", column, postGISShape(t, fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, 4326)", x1, y1, x2, y2))), nil
+		case "$polygon":
+			ring, err := geoPoints(coordinates)
+			if err != nil || len(ring) < 3 {
+				return "", fmt.Errorf("invalid value for $polygon (must be at least three points): %v", coordinates)
+			}
+			if t == FieldTypePoint {
+				return fmt.Sprintf("%q <@ %s::polygon", column, p.add(polygonText(ring))), nil
+			}
+			if ring[0] != ring[len(ring)-1] {
+				// GeoJSON polygons need to be closed.
+				ring = append(ring, ring[0])
+			}
+			geometry, err := geoJSON(map[string]any{"type": "Polygon", "coordinates": []any{ring}})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("ST_CoveredBy(%q, %s)", column, postGISShape(t, "ST_GeomFromGeoJSON("+p.add(geometry)+")")), nil
+		case "$center", "$centerSphere":
+			circle, ok := coordinates.([]any)
+			if !ok || len(circle) != 2 {
+				return "", fmt.Errorf("invalid value for %s (must be a point and a radius): %v", shape, coordinates)
+			}
+			x, y, err := geoPoint(circle[0])
+			if err != nil {
+				return "", fmt.Errorf("invalid value for %s: %w", shape, err)
+			}
+			radius, err := geoNumber(circle[1])
+			if err != nil || radius < 0 {
+				return "", fmt.Errorf("invalid value for %s (must be a point and a radius): %v", shape, coordinates)
+			}
+			switch {
+			case shape == "$centerSphere" && t == FieldTypePoint:
+				return "", fmt.Errorf("$centerSphere not supported on point columns")
+			case shape == "$centerSphere":
+				// The radius of $centerSphere is in radians.
+				return fmt.Sprintf("ST_DWithin(%s, %s::geography, %s)", geography(column, t), postGISPoint(p.add(x), p.add(y)), p.add(radius*earthRadius)), nil
+			case t == FieldTypePoint:
+				return fmt.Sprintf("%q <@ circle(point(%s, %s), %s)", column, p.add(x), p.add(y), p.add(radius)), nil
+			case t == FieldTypeGeometry:
+				return fmt.Sprintf("ST_DWithin(%q, %s, %s)", column, postGISPoint(p.add(x), p.add(y)), p.add(radius)), nil
+			default:
+				return "", fmt.Errorf("$center not supported on geography columns, use $centerSphere")
+			}
+		default:
+			return "", fmt.Errorf("unknown $geoWithin shape: %s", shape)
+		}
+	}
+	return "", nil
+}
+
+// geoIntersects converts $geoIntersects, which only supports $geometry.
+func (c *Converter) geoIntersects(column string, t FieldType, value any, p *boundValues) (string, error) {
+	v, ok := value.(map[string]any)
+	if !ok || v["$geometry"] == nil || len(v) != 1 {
+		return "", fmt.Errorf("invalid value for $geoIntersects operator (must be object with $geometry): %v", value)
+	}
+	if t == FieldTypePoint {
+		return "", fmt.Errorf("$geoIntersects operator not supported on point columns")
+	}
+	geometry, err := geoJSON(v["$geometry"])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ST_Intersects(%q, %s)", column, postGISShape(t, "ST_GeomFromGeoJSON("+p.add(geometry)+")")), nil
+}
+
+// geoDistance returns the expression to sort column on the distance to point, for example
+// {"location": {"$near": [4.9, 52.4]}}. As ORDER BY doesn't have values the coordinates are
+// formatted in the expression.
+func (c *Converter) geoDistance(column string, point any) (string, error) {
+	t, err := c.geoColumnType(column, "$near")
+	if err != nil {
+		return "", err
+	}
+	x, y, err := geoPoint(point)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for $near: %w", err)
+	}
+	xs, ys := strconv.FormatFloat(x, 'g', -1, 64), strconv.FormatFloat(y, 'g', -1, 64)
+	// The <-> operator can use an index to find the nearest rows.
+	if t == FieldTypePoint {
+		return fmt.Sprintf("%q <-> point(%s, %s)", column, xs, ys), nil
+	}
+	return fmt.Sprintf("%q <-> %s", column, postGISShape(t, postGISPoint(xs, ys))), nil
+}
+
+// postGISPoint returns a PostGIS geometry point with the WGS 84 coordinates x and y.
+func postGISPoint(x, y string) string {
+	return fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)", x, y)
+}
+
+// postGISShape returns the geometry shape as the type of the column.
+func postGISShape(t FieldType, shape string) string {
+	if t == FieldTypeGeography {
+		return shape + "::geography"
+	}
+	return shape
+}
+
+// geography returns column as geography, to calculate distances in meters.
+func geography(column string, t FieldType) string {
+	if t == FieldTypeGeography {
+		return fmt.Sprintf("%q", column)
+	}
+	return fmt.Sprintf("%q::geography", column)
+}
+
+// geoJSON returns the JSON text of a GeoJSON geometry.
+func geoJSON(v any) (string, error) {
+	geometry, ok := v.(map[string]any)
+	if _, isString := geometry["type"].(string); !ok || !isString || (geometry["coordinates"] == nil && geometry["geometries"] == nil) {
+		return "", fmt.Errorf("invalid value for $geometry (must be a GeoJSON geometry): %v", v)
+	}
+	return jsonbValue(geometry)
+}
+
+// geoJSONPolygonRing returns the exterior ring of a GeoJSON polygon.
+func geoJSONPolygonRing(v any) ([][2]float64, error) {
+	geometry, ok := v.(map[string]any)
+	rings, isArray := geometry["coordinates"].([]any)
+	if !ok || geometry["type"] != "Polygon" || !isArray || len(rings) != 1 {
+		return nil, fmt.Errorf("invalid value for $geometry (must be a GeoJSON polygon without holes): %v", v)
+	}
+	return geoPoints(rings[0])
+}
+
+// polygonText returns the text representation of a Postgres polygon, e.g. ((0,0),(1,1),(1,0)).
+func polygonText(ring [][2]float64) string {
+	points := make([]string, 0, len(ring))
+	for _, point := range ring {
+		points = append(points, fmt.Sprintf("(%s,%s)", strconv.FormatFloat(point[0], 'g', -1, 64), strconv.FormatFloat(point[1], 'g', -1, 64)))
+	}
+	return "(" + strings.Join(points, ",") + ")"
+}
+
+// geoPoint returns the coordinates of a legacy coordinate pair ([x, y]) or a GeoJSON point.
+func geoPoint(v any) (x, y float64, err error) {
+	if geometry, ok := v.(map[string]any); ok {
+		if geometry["type"] != "Point" {
+			return 0, 0, fmt.Errorf("must be a point: %v", v)
+		}
+		v = geometry["coordinates"]
+	}
+	coordinates, ok := v.([]any)
+	if !ok || len(coordinates) != 2 {
+		return 0, 0, fmt.Errorf("must be a point: %v", v)
+	}
+	if x, err = geoNumber(coordinates[0]); err != nil {
+		return 0, 0, err
+	}
+	if y, err = geoNumber(coordinates[1]); err != nil {
+		return 0, 0, err
+	}
+	return x, y, nil
+}
+
+// geoPoints returns the coordinates of a list of points.
+func geoPoints(v any) ([][2]float64, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("must be an array of points: %v", v)
+	}
+	points := make([][2]float64, 0, len(list))
+	for _, e := range list {
+		x, y, err := geoPoint(e)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, [2]float64{x, y})
+	}
+	return points, nil
+}
+
+// geoNumber returns v as a finite float64, coordinates and distances don't need exact decimals.
+func geoNumber(v any) (float64, error) {
+	v, err := normalizeValue(v)
+	if err != nil {
+		return 0, err
+	}
+	var f float64
+	switch n := v.(type) {
+	case int64:
+		f = float64(n)
+	case int:
+		f = float64(n)
+	case float64:
+		f = n
+	case Decimal:
+		f, err = strconv.ParseFloat(string(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("must be a number: %v", v)
+		}
+	default:
+		return 0, fmt.Errorf("must be a number: %v", v)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("must be a finite number: %v", v)
+	}
+	return f, nil
+}
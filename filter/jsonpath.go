@@ -0,0 +1,320 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// jsonPath compiles conditions on fields in the nested JSONB column into a
+// single SQL/JSON path predicate, see [WithJSONPath].
+//
+// All values are passed as jsonpath variables ($v1, $v2, ...), only regular
+// expressions have to be part of the path as like_regex doesn't support variables.
+type jsonPath struct {
+	c    *Converter
+	vars []any
+}
+
+// condition compiles a single key of a filter. ok is false if the condition can't be
+// compiled, in which case it should be converted to SQL as usual.
+func (p *jsonPath) condition(key string, value any) (predicate string, ok bool) {
+	varsBefore := len(p.vars)
+	defer func() {
+		if !ok {
+			// Remove the variables of a partially compiled condition.
+			p.vars = p.vars[:varsBefore]
+		}
+	}()
+
+	switch key {
+	case "$or", "$and", "$nor":
+		filters, ok := anyToSliceMapAny(value)
+		if !ok || len(filters) == 0 {
+			return "", false
+		}
+		inner := make([]string, 0, len(filters))
+		for _, f := range filters {
+			predicate, ok := p.filter(f)
+			if !ok {
+				return "", false
+			}
+			inner = append(inner, predicate)
+		}
+		switch key {
+		case "$nor":
+			return "!(" + strings.Join(inner, " || ") + ")", true
+		case "$or":
+			return "(" + strings.Join(inner, " || ") + ")", true
+		default:
+			return "(" + strings.Join(inner, " && ") + ")", true
+		}
+	case "$not":
+		f, ok := value.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		predicate, ok := p.filter(f)
+		if !ok {
+			return "", false
+		}
+		return "!(" + predicate + ")", true
+	default:
+		if !p.isNestedField(key) {
+			return "", false
+		}
+		return p.field(key, fmt.Sprintf("@.%q", key), value)
+	}
+}
+
+// filter compiles all keys of a filter, joined with &&.
+func (p *jsonPath) filter(filter map[string]any) (string, bool) {
+	if len(filter) == 0 {
+		return "", false
+	}
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	inner := make([]string, 0, len(keys))
+	for _, key := range keys {
+		predicate, ok := p.condition(key, filter[key])
+		if !ok {
+			return "", false
+		}
+		inner = append(inner, predicate)
+	}
+	if len(inner) == 1 {
+		return inner[0], true
+	}
+	return "(" + strings.Join(inner, " && ") + ")", true
+}
+
+// field compiles the conditions on a single field. key is used to look up the declared
+// field type, path is the jsonpath expression of the field (e.g. @."pet"). For the elements
+// of $elemMatch key is empty and path is @.
+func (p *jsonPath) field(key, path string, value any) (string, bool) {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return "", false
+	}
+
+	v, ok := value.(map[string]any)
+	if !ok {
+		return p.compare(key, path, "==", value)
+	}
+	if len(v) == 0 {
+		return "", false
+	}
+
+	operators := make([]string, 0, len(v))
+	for operator := range v {
+		operators = append(operators, operator)
+	}
+	sort.Strings(operators)
+
+	inner := make([]string, 0, len(operators))
+	for _, operator := range operators {
+		var predicate string
+		switch operator {
+		case "$in", "$nin":
+			elements, err := normalizeValue(v[operator])
+			if err != nil {
+				return "", false
+			}
+			elements, err = p.c.fieldValue(key, elements)
+			if err != nil {
+				return "", false
+			}
+			list, ok := elements.([]any)
+			if !ok || len(list) == 0 {
+				return "", false
+			}
+			or := make([]string, 0, len(list))
+			for _, e := range list {
+				predicate, ok := p.compare(key, path, "==", e)
+				if !ok {
+					return "", false
+				}
+				or = append(or, predicate)
+			}
+			predicate = "(" + strings.Join(or, " || ") + ")"
+			if operator == "$nin" {
+				predicate = "!" + predicate
+			}
+		case "$exists":
+			predicate = "exists(" + path + ")"
+			if !existsValue(v[operator]) {
+				predicate = "!" + predicate
+			}
+		case "$elemMatch":
+			elem, ok := p.field("", "@", v[operator])
+			if !ok {
+				return "", false
+			}
+			predicate = "exists(" + path + "[*] ? (" + elem + "))"
+		case "$field":
+			other, ok := v[operator].(string)
+			if !ok || key == "" || !p.isNestedField(other) {
+				return "", false
+			}
+			predicate = fmt.Sprintf("%s == @.%q", path, other)
+		case "$not":
+			switch not := v[operator].(type) {
+			case map[string]any:
+				negated, ok := p.field(key, path, not)
+				if !ok {
+					return "", false
+				}
+				predicate = "!(" + negated + ")"
+			case string:
+				if p.c.collation != "" {
+					return "", false
+				}
+				pattern, op, err := parseRegexLiteral(not)
+				if err != nil {
+					return "", false
+				}
+				literal, err := json.Marshal(pattern)
+				if err != nil {
+					return "", false
+				}
+				flag := ""
+				if op == "~*" {
+					flag = ` flag "i"`
+				}
+				predicate = fmt.Sprintf("!(%s like_regex %s%s)", path, literal, flag)
+			default:
+				return "", false
+			}
+		case "$regex":
+			pattern, ok := v[operator].(string)
+			if !ok || p.c.collation != "" {
+				return "", false
+			}
+			// like_regex only accepts a string literal, which uses the same escaping as JSON.
+			literal, err := json.Marshal(pattern)
+			if err != nil {
+				return "", false
+			}
+			predicate = fmt.Sprintf("%s like_regex %s flag \"i\"", path, literal)
+		default:
+			op, ok := jsonPathOperatorMap[operator]
+			if !ok {
+				return "", false
+			}
+			value, err := normalizeValue(v[operator])
+			if err != nil {
+				return "", false
+			}
+			if vv, ok := value.(map[string]any); ok {
+				other, ok := vv["$field"].(string)
+				if !ok || len(vv) > 1 || key == "" || !p.isNestedField(other) {
+					return "", false
+				}
+				predicate = fmt.Sprintf("%s %s @.%q", path, op, other)
+			} else if operator == "$ne" && p.c.mongoNulls {
+				// A missing field is never equal, so this also matches missing fields.
+				if predicate, ok = p.compare(key, path, "==", value); !ok {
+					return "", false
+				}
+				predicate = "!(" + predicate + ")"
+			} else if predicate, ok = p.compare(key, path, op, value); !ok {
+				return "", false
+			}
+		}
+		inner = append(inner, predicate)
+	}
+	if len(inner) == 1 {
+		return inner[0], true
+	}
+	return "(" + strings.Join(inner, " && ") + ")", true
+}
+
+var jsonPathOperatorMap = map[string]string{
+	"$eq":  "==",
+	"$ne":  "!=",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// compare compiles a comparison of path with a scalar value.
+func (p *jsonPath) compare(key, path, op string, value any) (string, bool) {
+	value, err := p.c.fieldValue(key, value)
+	if err != nil {
+		return "", false
+	}
+	if value == nil {
+		if op == "==" {
+			// Like IS NULL, a missing field is equal to null.
+			return fmt.Sprintf("(!exists(%s) || %s == null)", path, path), true
+		}
+		return fmt.Sprintf("%s %s null", path, op), true
+	}
+	variable, ok := p.variable(value)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s %s %s", path, op, variable), true
+}
+
+// variable adds value as a jsonpath variable and returns its name. Only values
+// that are compared the same way in jsonpath as in SQL can be used.
+func (p *jsonPath) variable(value any) (string, bool) {
+	switch v := value.(type) {
+	case bool:
+	case string:
+		// jsonpath compares strings without a collation.
+		if p.c.collation != "" {
+			return "", false
+		}
+	case Decimal:
+		if !decimalRegexp.MatchString(string(v)) {
+			return "", false
+		}
+		value = json.RawMessage(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", false
+		}
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return "", false
+		}
+	default:
+		// Dates and UUIDs are stored as strings, so they can't be compared in jsonpath.
+		if !isNumeric(value) {
+			return "", false
+		}
+	}
+	p.vars = append(p.vars, value)
+	return fmt.Sprintf("$v%d", len(p.vars)), true
+}
+
+func (p *jsonPath) isNestedField(key string) bool {
+	return key != p.c.placeholderName && isValidPostgresIdentifier(key) && p.c.isColumnAllowed(key) && p.c.isNestedColumn(key)
+}
+
+// sql returns the SQL condition for the compiled predicates and the values to bind.
+func (p *jsonPath) sql(predicates []string, paramIndex int) (string, []any, error) {
+	path := "$ ? (" + strings.Join(predicates, " && ") + ")"
+	if len(p.vars) == 0 {
+		return fmt.Sprintf("jsonb_path_exists(%q, $%d::jsonpath)", p.c.nestedColumn, paramIndex), []any{path}, nil
+	}
+
+	vars := make(map[string]any, len(p.vars))
+	for i, v := range p.vars {
+		vars[fmt.Sprintf("v%d", i+1)] = v
+	}
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("jsonb_path_exists(%q, $%d::jsonpath, $%d::jsonb)", p.c.nestedColumn, paramIndex, paramIndex+1), []any{path, string(b)}, nil
+}
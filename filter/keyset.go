@@ -0,0 +1,206 @@
+package filter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConvertKeyset converts a sort object (see [Converter.ConvertOrderBy]) into the conditions
+// for keyset pagination: they select the rows that come after the row with the values in
+// after, which is keyed by field name. This is much faster than OFFSET on large tables.
+//
+// tiebreaker is a unique column, like the primary key, which is added to the ORDER BY clause
+// when it's not in the sort object so the order is stable. after must contain the values of
+// all sorted fields and the tiebreaker, a missing field is seen as NULL. Use nil for the first page.
+//
+// For example {"level": -1} with tiebreaker "id" and after {"level": 10, "id": 4} becomes:
+//
+//	conditions: (("level" < $1 OR "level" IS NULL) OR ("level" = $1 AND "id" > $2))
+//	orderBy:    "level" DESC NULLS LAST, "id" ASC NULLS LAST
+//
+// The values start at startAtParameterIndex, so the conditions can be combined with those of
+// [Converter.Convert]. Use [EncodeCursor] and [DecodeCursor] to pass after to clients.
+func (c *Converter) ConvertKeyset(sort []byte, tiebreaker string, after map[string]any, startAtParameterIndex int) (conditions, orderBy string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+	if !isValidPostgresIdentifier(tiebreaker) {
+		return "", "", nil, fmt.Errorf("invalid column name: %s", tiebreaker)
+	}
+	if !c.isColumnAllowed(tiebreaker) {
+		return "", "", nil, ColumnNotAllowedError{Column: tiebreaker}
+	}
+
+	keys, _, err := c.sortKeys(sort, nil, startAtParameterIndex)
+	if err != nil {
+		return "", "", nil, err
+	}
+	hasTiebreaker := false
+	for _, key := range keys {
+		if key.computed {
+			return "", "", nil, fmt.Errorf("keyset pagination not supported when sorting on %s", key.field)
+		}
+		if key.field == tiebreaker {
+			hasTiebreaker = true
+		}
+	}
+	if !hasTiebreaker {
+		keys = append(keys, sortKey{field: tiebreaker, expressions: []string{c.columnName(tiebreaker, true)}, direction: "ASC", nulls: "NULLS LAST"})
+	}
+	orderBy = orderByClause(keys)
+
+	if after == nil {
+		return "TRUE", orderBy, nil, nil
+	}
+	if after[tiebreaker] == nil {
+		return "", "", nil, fmt.Errorf("keyset value for tiebreaker %s can't be null", tiebreaker)
+	}
+
+	// A row comes after the last row if it's equal on the first n expressions and
+	// after it on the next one, for example:
+	//
+	//   (a > $1 OR a IS NULL) OR (a = $1 AND b > $2) OR (a = $1 AND b = $2 AND c > $3)
+	//
+	// Row constructors like (a, b) > ($1, $2) can't be used for mixed directions and NULLs.
+	p := &boundValues{paramIndex: startAtParameterIndex}
+	var or, equal []string
+	for _, key := range keys {
+		keyValues, err := c.keysetValues(key, after[key.field])
+		if err != nil {
+			return "", "", nil, err
+		}
+		nullsLast := key.nulls == "NULLS LAST" || (key.nulls == "" && key.direction == "ASC")
+		for i, expression := range key.expressions {
+			if keyValues[i] == nil {
+				if !nullsLast {
+					// All values come after the NULLs that are sorted first.
+					or = append(or, keysetTerm(equal, expression+" IS NOT NULL"))
+				}
+				equal = append(equal, expression+" IS NULL")
+				continue
+			}
+
+			placeholder := p.add(keyValues[i])
+			op := ">"
+			if key.direction == "DESC" {
+				op = "<"
+			}
+			next := fmt.Sprintf("%s %s %s", expression, op, placeholder)
+			if nullsLast && key.field != tiebreaker {
+				next = fmt.Sprintf("(%s OR %s IS NULL)", next, expression)
+			}
+			or = append(or, keysetTerm(equal, next))
+			equal = append(equal, fmt.Sprintf("%s = %s", expression, placeholder))
+		}
+	}
+	if len(or) == 0 {
+		return "FALSE", orderBy, nil, nil
+	}
+
+	return "(" + strings.Join(or, " OR ") + ")", orderBy, p.values, nil
+}
+
+// keysetTerm returns the condition for rows that are equal on the expressions of equal, and after
+// the last row on the next expression.
+func keysetTerm(equal []string, next string) string {
+	if len(equal) == 0 {
+		return next
+	}
+	return "(" + strings.Join(equal, " AND ") + " AND " + next + ")"
+}
+
+// keysetValues returns the values of the expressions of key for the field value v.
+func (c *Converter) keysetValues(key sortKey, v any) ([]any, error) {
+	v, err := normalizeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if !isScalar(v) {
+		return nil, fmt.Errorf("invalid keyset value for %s (must be a primitive): %v", key.field, v)
+	}
+	v, err = c.fieldValue(key.field, v)
+	if err != nil {
+		return nil, err
+	}
+	if len(key.expressions) == 1 {
+		return []any{v}, nil
+	}
+
+	// JSONB fields are sorted on their numeric value first, and then on their text.
+	var text any
+	switch vv := v.(type) {
+	case nil:
+		return []any{nil, nil}, nil
+	case string:
+		return []any{nil, vv}, nil
+	case bool:
+		text = strconv.FormatBool(vv)
+	case int:
+		text = strconv.Itoa(vv)
+	case int64:
+		text = strconv.FormatInt(vv, 10)
+	case float64:
+		text = strconv.FormatFloat(vv, 'g', -1, 64)
+	case Decimal:
+		text = string(vv)
+	default:
+		return nil, fmt.Errorf("invalid keyset value for %s: %v", key.field, v)
+	}
+	if isNumeric(v) {
+		return []any{v, text}, nil
+	}
+	return []any{nil, text}, nil
+}
+
+// EncodeCursor encodes the values of the last row of a page (see [Converter.ConvertKeyset])
+// into an opaque token that can be given to clients. The token is signed with secret, so
+// clients can't change the values.
+func EncodeCursor(secret []byte, values map[string]any) (string, error) {
+	if len(secret) == 0 {
+		return "", fmt.Errorf("cursor secret can't be empty")
+	}
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(cursorSignature(secret, payload)), nil
+}
+
+// DecodeCursor decodes and verifies a token created with [EncodeCursor]. It returns
+// [ErrInvalidCursor] if the token is malformed or wasn't signed with secret.
+func DecodeCursor(secret []byte, cursor string) (map[string]any, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("cursor secret can't be empty")
+	}
+	encodedPayload, encodedSignature, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, cursorSignature(secret, payload)) {
+		return nil, ErrInvalidCursor
+	}
+	var values map[string]any
+	if err := decodeJSON(bytes.NewReader(payload), &values); err != nil || values == nil {
+		return nil, ErrInvalidCursor
+	}
+	return values, nil
+}
+
+func cursorSignature(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload) //nolint:errcheck
+	return mac.Sum(nil)
+}
@@ -90,6 +90,42 @@ func WithEmptyCondition(condition string) Option {
 	}
 }
 
+// WithMaxLimit is an option to set the maximum limit of [Converter.ConvertFind] and
+// [Converter.ConvertPipeline]. Higher limits are rejected, and the maximum is used when
+// there is no limit.
+func WithMaxLimit(limit int) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.maxLimit = int64(limit)
+		},
+	}
+}
+
+// WithTable is an option to specify the table that [Converter.ConvertPipeline] selects from.
+func WithTable(table string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.table = table
+		},
+	}
+}
+
+// WithWritableColumns is an option to specify the columns that can be updated with
+// [Converter.ConvertUpdate]. This is separate from the access options, so columns can be
+// filtered on without being writable. Fields in the nested JSONB column can be declared one
+// by one, or all at once by declaring the nested column.
+//
+// Example:
+//
+//	c := filter.NewConverter(filter.WithNestedJSONB("metadata", "level"), filter.WithWritableColumns("level", "metadata"))
+func WithWritableColumns(columns ...string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.writableColumns = append(c.writableColumns, columns...)
+		},
+	}
+}
+
 // WithPlaceholderName is an option to specify the placeholder name that will be
 // used in the generated SQL query. This name should not be used in the database
 // or any JSONB column.
@@ -100,3 +136,254 @@ func WithPlaceholderName(name string) Option {
 		},
 	}
 }
+
+// WithJSONBContainment is an option to compare fields in the nested JSONB column using
+// the containment operator, so the query can use a GIN index on the JSONB column.
+//
+// With this option {"pet": "dog"} becomes `("meta" @> $1::jsonb)` with `{"pet":"dog"}` as
+// value, instead of `("meta"->>'pet' = $1)`. This is used for $eq, $in and implicit
+// equality with string and boolean values. Other values, like numbers, null and strings
+// such as "20" that also match a stored number or boolean, are compared as usual because
+// containment would change the semantics.
+func WithJSONBContainment() Option {
+	return Option{
+		f: func(c *Converter) {
+			c.jsonbContainment = true
+		},
+	}
+}
+
+// WithJSONPath is an option to convert conditions on fields in the nested JSONB column
+// into a single SQL/JSON path expression, which can use a GIN index on the JSONB column.
+//
+// With this option {"pet": "dog", "level": {"$gt": 10}} becomes:
+//
+//	jsonb_path_exists("meta", $1::jsonpath, $2::jsonb)
+//
+// with `$ ? (@."level" > $v1 && @."pet" == $v2)` and `{"v1":10,"v2":"dog"}` as values.
+//
+// Conditions follow the SQL/JSON path semantics: values are only equal if they have the
+// same JSON type, and arrays are matched if any of their elements match. Conditions that
+// can't be expressed in a path, for example on dates, are converted as usual.
+func WithJSONPath() Option {
+	return Option{
+		f: func(c *Converter) {
+			c.jsonPath = true
+		},
+	}
+}
+
+// WithImplicitArrayMatching is an option to make conditions on fields in the nested JSONB
+// column also match arrays, like MongoDB does. With this option {"hats": "cap"} matches
+// both `"hats": "cap"` and `"hats": ["cap", "helmet"]`.
+//
+// This is used for implicit equality and for $eq, $ne, $gt, $gte, $lt, $lte, $regex, $in
+// and $nin. Like in MongoDB, $ne and $nin only match arrays if none of the elements match.
+// A jsonb_typeof check is used to decide if the field is an array, so scalar fields are
+// compared as before.
+func WithImplicitArrayMatching() Option {
+	return Option{
+		f: func(c *Converter) {
+			c.implicitArrays = true
+		},
+	}
+}
+
+// WithMongoNullSemantics is an option to make negations match NULL values and missing
+// JSONB fields, like MongoDB does. Without this option {"mount": {"$ne": "horse"}} doesn't
+// match rows where mount is NULL, because of the three-valued logic of SQL.
+//
+// With this option:
+//   - $ne uses IS DISTINCT FROM, so {"$ne": null} matches all values that aren't NULL.
+//   - $nin and $nor match NULL, and null in the list of $in and $nin matches NULL.
+//   - null matches missing JSONB fields, {"pet": null} becomes ("meta"->>'pet' IS NULL).
+//
+// $not already matches NULL values without this option.
+func WithMongoNullSemantics() Option {
+	return Option{
+		f: func(c *Converter) {
+			c.mongoNulls = true
+		},
+	}
+}
+
+// WithKnownColumns is an option to declare all columns of the table, so $exists can be used
+// on columns that aren't in the nested JSONB column. Without this option $exists is only
+// supported on fields in the nested JSONB column, as Postgres can't check if a column exists.
+//
+// With this option {"col": {"$exists": true}} becomes TRUE if col is one of the known columns,
+// and FALSE otherwise. Use [WithExistsPolicy] to check for NULL values instead. This allows
+// the same filters to be used when a field is moved from the JSONB column to a real column.
+//
+// The known columns are also selected by exclusion projections, see [Converter.ConvertProjection].
+//
+// Example:
+//
+//	c := filter.NewConverter(filter.WithNestedJSONB("metadata", "level"), filter.WithKnownColumns("id", "level", "metadata"))
+func WithKnownColumns(columns ...string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.knownColumns = append(c.knownColumns, columns...)
+		},
+	}
+}
+
+// ExistsPolicy decides how $exists is converted for the columns declared with [WithKnownColumns].
+type ExistsPolicy string
+
+const (
+	// ExistsColumn converts $exists to TRUE or FALSE, a known column always exists. This is the default.
+	ExistsColumn ExistsPolicy = "column"
+	// ExistsNotNull converts $exists to IS NOT NULL, so a NULL value is seen as a missing field.
+	ExistsNotNull ExistsPolicy = "not_null"
+)
+
+// WithExistsPolicy is an option to specify how $exists is converted for the columns declared
+// with [WithKnownColumns]. The default is [ExistsColumn].
+func WithExistsPolicy(policy ExistsPolicy) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.existsPolicy = policy
+		},
+	}
+}
+
+// NullsOrder decides where NULL values are sorted by [Converter.ConvertOrderBy].
+type NullsOrder string
+
+const (
+	// NullsLast sorts NULL values after all other values, in both directions. This is the default.
+	NullsLast NullsOrder = "last"
+	// NullsFirst sorts NULL values before all other values, in both directions.
+	NullsFirst NullsOrder = "first"
+)
+
+// WithDefaultNulls is an option to specify where NULL values are sorted for fields that don't
+// set nulls in the sort object. The default is [NullsLast].
+func WithDefaultNulls(nulls NullsOrder) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.defaultNulls = nulls
+		},
+	}
+}
+
+// WithCollations is an option to declare the collations that can be used in sort objects, like
+// {"name": {"direction": 1, "collation": "und-x-icu"}}. Other collations are rejected, as the
+// sort object usually comes from user input. Like WithDefaultCollation, they can only be used for
+// fields in the nested JSONB column and columns declared as text with WithFieldTypes.
+func WithCollations(collations ...string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.collations = append(c.collations, collations...)
+		},
+	}
+}
+
+// WithDefaultCollation is an option to compare strings with a collation, for example to match
+// case insensitively with a nondeterministic ICU collation. It's used when comparing with string
+// values ($eq, $ne, $in, $nin, $regex, $gt, ...) and for sorting. Only fields in the nested JSONB
+// column and columns declared as text with WithFieldTypes use it, as other columns like timestamps
+// and UUIDs don't support collations. The collation is always allowed in sort objects.
+//
+// Example:
+//
+//	c := filter.NewConverter(
+//		filter.WithNestedJSONB("meta", "name"),
+//		filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText}),
+//		filter.WithDefaultCollation("und-x-icu"),
+//	)
+//
+// Note that Postgres doesn't support regular expressions with nondeterministic collations.
+func WithDefaultCollation(collation string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.collation = collation
+		},
+	}
+}
+
+// WithTextSearch is an option to specify the text columns (or fields in the nested JSONB
+// column) that are searched with the $text operator. For example with "title" and "body":
+//
+//	{"$text": {"$search": "dragon rider"}}
+//
+// becomes:
+//
+//	(to_tsvector('english', coalesce("title", '') || ' ' || coalesce("body", '')) @@ websearch_to_tsquery('english', $1))
+//
+// The language is taken from $language and defaults to english. To use an index, create it
+// on the same expression, or use [WithTextSearchVector] with a tsvector column.
+func WithTextSearch(columns ...string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.textSearchColumns = append(c.textSearchColumns, columns...)
+		},
+	}
+}
+
+// WithTextSearchVector is an option to specify the tsvector column that is searched with the
+// $text operator, for example a generated column with a GIN index:
+//
+//	"search" tsvector GENERATED ALWAYS AS (to_tsvector('english', "title")) STORED
+//
+// The $language of the filter is only used for the query in this case.
+func WithTextSearchVector(column string) Option {
+	return Option{
+		f: func(c *Converter) {
+			c.textSearchVector = column
+		},
+	}
+}
+
+// FieldType is the type of a field, used to generate typed comparisons.
+type FieldType string
+
+const (
+	// FieldTypeTimestamp is a date and time, compared as a timestamptz.
+	FieldTypeTimestamp FieldType = "timestamptz"
+	// FieldTypeGeometry is a PostGIS geometry column with WGS 84 (SRID 4326) coordinates,
+	// which can be used with the geospatial operators.
+	FieldTypeGeometry FieldType = "geometry"
+	// FieldTypeGeography is a PostGIS geography column, which can be used with the geospatial operators.
+	FieldTypeGeography FieldType = "geography"
+	// FieldTypePoint is a native Postgres point column, which can be used with $near, $geoWithin
+	// $box, $polygon and $center. Distances are in the units of the coordinates.
+	FieldTypePoint FieldType = "point"
+	// FieldTypeNumeric is a number, sorted as `("meta"->>'score')::numeric`.
+	FieldTypeNumeric FieldType = "numeric"
+	// FieldTypeBoolean is a boolean, sorted as `("meta"->>'active')::boolean`.
+	FieldTypeBoolean FieldType = "boolean"
+	// FieldTypeText is a string, sorted as `"meta"->>'name'`.
+	FieldTypeText FieldType = "text"
+)
+
+// WithFieldTypes is an option to declare the type of fields. This is mostly useful for
+// fields in the nested JSONB column, which are otherwise compared as text.
+//
+// For example, a nested field declared as [FieldTypeTimestamp] is compared with
+// `("meta"->>'last_seen')::timestamptz` and string values in the filter are parsed as
+// RFC 3339 timestamps. Stored values that aren't valid timestamps are treated as NULL
+// instead of failing the whole query.
+//
+// Nested fields declared as [FieldTypeNumeric], [FieldTypeBoolean] or [FieldTypeText] are
+// sorted on a single typed expression that can use an expression index, instead of on both
+// their numeric and text value. Comparisons in filters already use the type of the value.
+//
+// Example:
+//
+//	c := filter.NewConverter(filter.WithNestedJSONB("meta"), filter.WithFieldTypes(map[string]filter.FieldType{
+//		"last_seen": filter.FieldTypeTimestamp,
+//	}))
+func WithFieldTypes(types map[string]FieldType) Option {
+	return Option{
+		f: func(c *Converter) {
+			if c.fieldTypes == nil {
+				c.fieldTypes = map[string]FieldType{}
+			}
+			for field, t := range types {
+				c.fieldTypes[field] = t
+			}
+		},
+	}
+}
@@ -0,0 +1,770 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseConditions converts conditions produced by [Converter.Convert] with its values back into
+// a MongoDB filter query, so converting the filter again results in the same conditions and values.
+// This is useful for conditions that were stored instead of the filter they were converted from.
+//
+// startAtParameterIndex has to be the index that was passed to Convert. Only the SQL Convert
+// itself produces is supported, with the options of the Converter that produced it, and $expr,
+// $text and the geospatial operators can't be parsed. Values must be the values returned by
+// Convert without an array driver (see [WithArrayDriver]), for example decoded from JSON.
+//
+// Example:
+//
+//	filter, err := converter.ParseConditions(`(("level" > $1) AND ("name" = $2))`, []any{10, "Alice"}, 1)
+//	// {"level":{"$gt":10},"name":"Alice"}
+func (c *Converter) ParseConditions(conditions string, values []any, startAtParameterIndex int) ([]byte, error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+	switch {
+	case c.jsonbContainment:
+		return nil, fmt.Errorf("ParseConditions doesn't support WithJSONBContainment")
+	case c.jsonPath:
+		return nil, fmt.Errorf("ParseConditions doesn't support WithJSONPath")
+	case c.implicitArrays:
+		return nil, fmt.Errorf("ParseConditions doesn't support WithImplicitArrayMatching")
+	case c.mongoNulls:
+		return nil, fmt.Errorf("ParseConditions doesn't support WithMongoNullSemantics")
+	case c.collation != "":
+		return nil, fmt.Errorf("ParseConditions doesn't support WithDefaultCollation")
+	}
+
+	if conditions == c.emptyCondition {
+		return []byte("{}"), nil
+	}
+
+	p := &conditionParser{c: c, sql: conditions, values: values, paramIndex: startAtParameterIndex}
+	condition, err := p.expression()
+	if err == nil && p.pos != len(p.sql) {
+		err = p.unsupported()
+	}
+	if _, ok := err.(*syntaxError); ok {
+		return nil, p.furthest
+	} else if err != nil {
+		return nil, err
+	}
+	filter, err := condition.filter()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(filter)
+}
+
+// parsedCondition is a condition parsed by conditionParser.
+type parsedCondition struct {
+	// kind is "and", "or", "nor", "not" or "elemMatch" for conditions with other conditions, or
+	// "compare", "field", "in", "nin", "exists", "notExists", "isNull" and "nestedIsNull" for
+	// conditions on field.
+	kind       string
+	conditions []parsedCondition
+	field      string
+	// operator is the SQL operator of "compare" and "field", like ">=". Fields compared with
+	// $eq or $field that can't be compared with the other operator have those instead of "=".
+	operator string
+	// value is the value of "compare", "in" and "nin", the other field of "field", or the
+	// placeholder of "elemMatch".
+	value any
+}
+
+var parsedOperators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+	"~*": "$regex",
+}
+
+// filter returns the MongoDB filter of the condition.
+func (pc parsedCondition) filter() (map[string]any, error) {
+	switch pc.kind {
+	case "and":
+		// {"name": null} on a nested field checks if it exists, see convertFilter.
+		if len(pc.conditions) == 2 && pc.conditions[0].kind == "exists" && pc.conditions[1].kind == "nestedIsNull" && pc.conditions[0].field == pc.conditions[1].field {
+			return map[string]any{pc.conditions[0].field: nil}, nil
+		}
+		filters, err := parsedFilters(pc.conditions)
+		if err != nil {
+			return nil, err
+		}
+		if merged, ok := mergeOperators(pc.conditions, filters); ok {
+			return merged, nil
+		}
+		if merged, ok := mergeFields(filters); ok {
+			return merged, nil
+		}
+		// A top level $not might be in the right place where the field level $not isn't.
+		for i, condition := range pc.conditions {
+			// Regular expression literals can only be used in the field level $not.
+			if condition.kind == "not" && condition.conditions[0].operator != "~" {
+				if filters[i], err = condition.conditions[0].filter(); err != nil {
+					return nil, err
+				}
+				filters[i] = map[string]any{"$not": filters[i]}
+			}
+		}
+		if merged, ok := mergeFields(filters); ok {
+			return merged, nil
+		}
+		return map[string]any{"$and": filters}, nil
+	case "or", "nor":
+		filters, err := parsedFilters(pc.conditions)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"$" + pc.kind: filters}, nil
+	case "not":
+		inner := pc.conditions[0]
+		if inner.kind == "compare" && inner.operator == "~" {
+			// A case sensitive match is only produced by a regular expression literal.
+			pattern, ok := inner.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid regular expression: %v", inner.value)
+			}
+			return map[string]any{inner.field: map[string]any{"$not": "/" + pattern + "/"}}, nil
+		}
+		filter, err := inner.filter()
+		if err != nil {
+			return nil, err
+		}
+		// Prefer the field level $not, which can be merged with other operators on the field.
+		for field, value := range filter {
+			if len(filter) != 1 || strings.HasPrefix(field, "$") || value == nil {
+				break
+			}
+			operators, ok := value.(map[string]any)
+			if !ok {
+				operators = map[string]any{"$eq": value}
+			}
+			return map[string]any{field: map[string]any{"$not": operators}}, nil
+		}
+		return map[string]any{"$not": filter}, nil
+	case "elemMatch":
+		filter, err := pc.conditions[0].filter()
+		if err != nil {
+			return nil, err
+		}
+		// The conditions are on the placeholder, see convertFilter.
+		value, ok := filter[pc.value.(string)]
+		if !ok || len(filter) != 1 {
+			return nil, fmt.Errorf("unsupported $elemMatch condition on %s", pc.field)
+		}
+		return map[string]any{pc.field: map[string]any{"$elemMatch": value}}, nil
+	case "compare":
+		operator, ok := parsedOperators[pc.operator]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator: %s", pc.operator)
+		}
+		if _, isObject := pc.value.(map[string]any); operator == "$eq" && pc.value != nil && !isObject {
+			return map[string]any{pc.field: pc.value}, nil
+		}
+		return map[string]any{pc.field: map[string]any{operator: pc.value}}, nil
+	case "field":
+		other := map[string]any{"$field": pc.value}
+		if pc.operator == "$field" {
+			return map[string]any{pc.field: other}, nil
+		}
+		if pc.operator == "$eq" {
+			return map[string]any{pc.field: map[string]any{"$eq": other}}, nil
+		}
+		operator, ok := parsedOperators[pc.operator]
+		if !ok || operator == "$regex" {
+			return nil, fmt.Errorf("unsupported operator: %s", pc.operator)
+		}
+		return map[string]any{pc.field: map[string]any{operator: other}}, nil
+	case "in", "nin":
+		return map[string]any{pc.field: map[string]any{"$" + pc.kind: pc.value}}, nil
+	case "exists", "notExists":
+		return map[string]any{pc.field: map[string]any{"$exists": pc.kind == "exists"}}, nil
+	case "isNull":
+		return map[string]any{pc.field: nil}, nil
+	case "nestedIsNull":
+		return nil, fmt.Errorf("unsupported NULL check on nested field %s (must check if it exists first)", pc.field)
+	}
+	return nil, fmt.Errorf("unsupported condition: %s", pc.kind)
+}
+
+func parsedFilters(conditions []parsedCondition) ([]any, error) {
+	filters := make([]any, 0, len(conditions))
+	for _, condition := range conditions {
+		filter, err := condition.filter()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// mergeOperators merges filters of conditions on the same field, like {"level": {"$gt": 1}}
+// and {"level": {"$lt": 5}}, into one object. Convert sorts the operators, so this is only
+// possible if they're in that order.
+func mergeOperators(conditions []parsedCondition, filters []any) (map[string]any, bool) {
+	var field string
+	operators := map[string]any{}
+	previous := ""
+	for i, f := range filters {
+		f := f.(map[string]any)
+		if len(f) != 1 {
+			return nil, false
+		}
+		for key, value := range f {
+			if i > 0 && key != field {
+				return nil, false
+			}
+			field = key
+			if strings.HasPrefix(key, "$") {
+				return nil, false
+			}
+			if value == nil {
+				return nil, false
+			}
+			v, ok := value.(map[string]any)
+			if !ok {
+				v = map[string]any{"$eq": value}
+			}
+			// Filters with more operators are in parentheses of their own.
+			if len(v) != 1 {
+				return nil, false
+			}
+			for operator, operand := range v {
+				if operator <= previous && conditions[i].kind == "field" && conditions[i].operator == "=" {
+					// {"$eq": {"$field": "b"}} is the same as {"$field": "b"}.
+					operator, operand = "$field", conditions[i].value
+				}
+				if operator <= previous {
+					return nil, false
+				}
+				previous = operator
+				operators[operator] = operand
+			}
+		}
+	}
+	return map[string]any{field: operators}, true
+}
+
+// mergeFields merges filters with one key into one object, if the keys are sorted like
+// Convert sorts them.
+func mergeFields(filters []any) (map[string]any, bool) {
+	merged := map[string]any{}
+	previous := ""
+	for _, f := range filters {
+		f := f.(map[string]any)
+		if len(f) != 1 {
+			return nil, false
+		}
+		for key, value := range f {
+			if key <= previous {
+				return nil, false
+			}
+			previous = key
+			merged[key] = value
+		}
+	}
+	return merged, true
+}
+
+// conditionParser parses the SQL produced by convertFilter.
+type conditionParser struct {
+	c          *Converter
+	sql        string
+	pos        int
+	values     []any
+	paramIndex int
+	// placeholder is true in the conditions of $elemMatch.
+	placeholder bool
+	furthest    *syntaxError
+}
+
+// syntaxError is returned for SQL that isn't supported. Unlike other errors the parser
+// tries alternatives after these.
+type syntaxError struct {
+	pos int
+	sql string
+}
+
+func (e *syntaxError) Error() string {
+	rest := e.sql[e.pos:]
+	if len(rest) > 40 {
+		rest = rest[:40] + "..."
+	}
+	return fmt.Sprintf("unsupported SQL at position %d: %s", e.pos, rest)
+}
+
+// unsupported returns a syntaxError at the current position, and keeps the one that got
+// the furthest to report it if all alternatives fail.
+func (p *conditionParser) unsupported() error {
+	err := &syntaxError{pos: p.pos, sql: p.sql}
+	if p.furthest == nil || p.pos >= p.furthest.pos {
+		p.furthest = err
+	}
+	return err
+}
+
+// attempt parses a predicate, and resets the position if that fails with a syntax error.
+func (p *conditionParser) attempt() (condition parsedCondition, ok bool, err error) {
+	start := p.pos
+	condition, err = p.predicate()
+	if _, isSyntaxError := err.(*syntaxError); isSyntaxError {
+		p.pos = start
+		return parsedCondition{}, false, nil
+	}
+	return condition, err == nil, err
+}
+
+// consume skips s if the SQL continues with it.
+func (p *conditionParser) consume(s string) bool {
+	if strings.HasPrefix(p.sql[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) expect(s string) error {
+	if !p.consume(s) {
+		return p.unsupported()
+	}
+	return nil
+}
+
+// until returns the SQL up to s, and skips both.
+func (p *conditionParser) until(s string) (string, error) {
+	i := strings.Index(p.sql[p.pos:], s)
+	if i < 0 {
+		return "", p.unsupported()
+	}
+	result := p.sql[p.pos : p.pos+i]
+	p.pos += i + len(s)
+	return result, nil
+}
+
+// expression parses conditions joined with AND or OR.
+func (p *conditionParser) expression() (parsedCondition, error) {
+	kind, conditions, err := p.list()
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	return parsedCondition{kind: kind, conditions: conditions}, nil
+}
+
+// list parses conditions joined with AND or OR, and returns "and" or "or" with the conditions.
+func (p *conditionParser) list() (string, []parsedCondition, error) {
+	kind := ""
+	var conditions []parsedCondition
+	for {
+		condition, err := p.term()
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+
+		next := ""
+		if p.consume(" AND ") {
+			next = "and"
+		} else if p.consume(" OR ") {
+			next = "or"
+		} else {
+			return kind, conditions, nil
+		}
+		if kind != "" && kind != next {
+			return "", nil, p.unsupported()
+		}
+		kind = next
+	}
+}
+
+// term parses a single condition, which might be an expression in parentheses.
+func (p *conditionParser) term() (parsedCondition, error) {
+	if p.consume("(NOT COALESCE(") {
+		inner, err := p.expression()
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		if err := p.expect(", FALSE))"); err != nil {
+			return parsedCondition{}, err
+		}
+		return parsedCondition{kind: "not", conditions: []parsedCondition{inner}}, nil
+	}
+
+	if p.consume("NOT ") {
+		// NOT "name" = ANY($1) for $nin, or NOT ((...) OR (...)) for $nor.
+		start := p.pos
+		condition, ok, err := p.attempt()
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		switch {
+		case ok && condition.kind == "in":
+			condition.kind = "nin"
+			return condition, nil
+		case ok && condition.kind == "exists":
+			condition.kind = "notExists"
+			return condition, nil
+		}
+		p.pos = start
+		if err := p.expect("("); err != nil {
+			return parsedCondition{}, err
+		}
+		kind, conditions, err := p.list()
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		if kind == "and" {
+			return parsedCondition{}, fmt.Errorf("unsupported NOT of AND conditions")
+		}
+		if err := p.expect(")"); err != nil {
+			return parsedCondition{}, err
+		}
+		return parsedCondition{kind: "nor", conditions: conditions}, nil
+	}
+
+	if p.consume("EXISTS (SELECT 1 FROM ") {
+		return p.elemMatch()
+	}
+
+	// Both a condition in parentheses and a cast column like ("meta"->>'level')::numeric start with
+	// a parenthesis, so try the latter first.
+	if condition, ok, err := p.attempt(); ok || err != nil {
+		return condition, err
+	}
+	if err := p.expect("("); err != nil {
+		return parsedCondition{}, err
+	}
+	condition, err := p.expression()
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	if err := p.expect(")"); err != nil {
+		return parsedCondition{}, err
+	}
+	return condition, nil
+}
+
+// elemMatch parses the rest of an $elemMatch subquery:
+//
+//	EXISTS (SELECT 1 FROM jsonb_array_elements("meta"->'foo') AS __filter_placeholder WHERE (...))
+func (p *conditionParser) elemMatch() (parsedCondition, error) {
+	if !p.consume("jsonb_array_elements(") && !p.consume("unnest(") {
+		return parsedCondition{}, p.unsupported()
+	}
+	field, err := p.column()
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	if err := p.expect(") AS " + p.c.placeholderName + " WHERE "); err != nil {
+		return parsedCondition{}, err
+	}
+	placeholder := p.placeholder
+	p.placeholder = true
+	inner, err := p.expression()
+	p.placeholder = placeholder
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	if err := p.expect(")"); err != nil {
+		return parsedCondition{}, err
+	}
+	return parsedCondition{kind: "elemMatch", field: field, value: p.c.placeholderName, conditions: []parsedCondition{inner}}, nil
+}
+
+// predicate parses a condition on a field, like "level" > $1.
+func (p *conditionParser) predicate() (parsedCondition, error) {
+	var key string
+	var exists bool
+	switch {
+	case p.consume("jsonb_path_exists("):
+		// See fieldExists.
+		column, err := p.until(", ")
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		if column != fmt.Sprintf("%q", p.c.nestedColumn) {
+			return parsedCondition{}, fmt.Errorf("unknown nested column: %s", column)
+		}
+		value, err := p.param()
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		path, ok := value.(jsonPathValue)
+		if !ok {
+			return parsedCondition{}, p.unsupported()
+		}
+		key, err = strconv.Unquote(strings.TrimPrefix(string(path), "$."))
+		if err != nil || !strings.HasPrefix(string(path), "$.") {
+			return parsedCondition{}, fmt.Errorf("unsupported jsonpath: %s", path)
+		}
+		if err := p.expect(")"); err != nil {
+			return parsedCondition{}, err
+		}
+		exists = true
+	case p.consume("jsonb_path_match("):
+		// Earlier versions inlined the key: jsonb_path_match(meta, 'exists($.key)').
+		column, err := p.until(", 'exists($.")
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		if column != p.c.nestedColumn && column != fmt.Sprintf("%q", p.c.nestedColumn) {
+			return parsedCondition{}, fmt.Errorf("unknown nested column: %s", column)
+		}
+		if key, err = p.until(")')"); err != nil {
+			return parsedCondition{}, err
+		}
+		exists = true
+	}
+	if exists {
+		if key == p.c.placeholderName && p.placeholder {
+			return parsedCondition{kind: "exists", field: key}, nil
+		}
+		field, err := p.field(key)
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		return parsedCondition{kind: "exists", field: field}, nil
+	}
+
+	start := p.pos
+	field, err := p.operand()
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	// Casts start with a parenthesis, see castValue.
+	cast := strings.HasPrefix(p.sql[start:], "(")
+	if p.consume(" IS NULL") {
+		if p.c.nestedColumn != "" && (field == p.c.placeholderName || p.c.isNestedColumn(field)) {
+			// Convert checks if nested fields exist before checking for NULL, see filter.
+			return parsedCondition{kind: "nestedIsNull", field: field}, nil
+		}
+		return parsedCondition{kind: "isNull", field: field}, nil
+	}
+	if p.consume(" = ANY(") {
+		value, err := p.param()
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		if err := p.expect(")"); err != nil {
+			return parsedCondition{}, err
+		}
+		return parsedCondition{kind: "in", field: field, value: value}, nil
+	}
+
+	if err := p.expect(" "); err != nil {
+		return parsedCondition{}, err
+	}
+	operator, err := p.until(" ")
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	if _, ok := parsedOperators[operator]; !ok && operator != "~" {
+		return parsedCondition{}, fmt.Errorf("unsupported operator: %s", operator)
+	}
+	if strings.HasPrefix(p.sql[p.pos:], "$") {
+		value, err := p.param()
+		if err != nil {
+			return parsedCondition{}, err
+		}
+		return parsedCondition{kind: "compare", field: field, operator: operator, value: value}, nil
+	}
+	start = p.pos
+	other, err := p.operand()
+	if err != nil {
+		return parsedCondition{}, err
+	}
+	cast = cast || strings.HasPrefix(p.sql[start:], "(")
+	timestamp := func(field string) bool {
+		return p.c.fieldTypes[field] == FieldTypeTimestamp && p.c.isNestedColumn(field)
+	}
+	if operator == "=" && (timestamp(field) || timestamp(other)) {
+		// {"$eq": {"$field": ...}} casts declared timestamps, {"$field": ...} doesn't. Otherwise
+		// they're the same, see mergeOperators.
+		operator = "$field"
+		if cast {
+			operator = "$eq"
+		}
+	}
+	return parsedCondition{kind: "field", field: field, operator: operator, value: other}, nil
+}
+
+// operand parses a column, which might be cast to compare it with a value, and returns its field.
+func (p *conditionParser) operand() (string, error) {
+	if p.consume("(CASE WHEN jsonb_typeof(") {
+		// See castValue.
+		if _, err := p.column(); err != nil {
+			return "", err
+		}
+		if err := p.expect(") = 'boolean' THEN ("); err != nil {
+			return "", err
+		}
+		field, err := p.column()
+		if err != nil {
+			return "", err
+		}
+		return field, p.expect(")::boolean END)")
+	}
+	if p.consume("(CASE WHEN ") {
+		if _, err := p.column(); err != nil {
+			return "", err
+		}
+		if err := p.expect(" ~ '" + timestampRegexp + "' THEN ("); err != nil {
+			return "", err
+		}
+		field, err := p.column()
+		if err != nil {
+			return "", err
+		}
+		return field, p.expect(")::timestamptz END)")
+	}
+	if p.consume("(") {
+		field, err := p.column()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(")::"); err != nil {
+			return "", err
+		}
+		if !p.consume("numeric") && !p.consume("uuid") && !p.consume("timestamptz") && !p.consume("boolean") {
+			return "", p.unsupported()
+		}
+		return field, nil
+	}
+	return p.column()
+}
+
+// column parses a quoted column, or a field in the nested JSONB column like "meta"->>'level',
+// and returns its field.
+func (p *conditionParser) column() (string, error) {
+	if !strings.HasPrefix(p.sql[p.pos:], `"`) {
+		return "", p.unsupported()
+	}
+	end := strings.Index(p.sql[p.pos+1:], `"`)
+	if end < 0 {
+		return "", p.unsupported()
+	}
+	name, err := strconv.Unquote(p.sql[p.pos : p.pos+end+2])
+	if err != nil {
+		return "", p.unsupported()
+	}
+	p.pos += end + 2
+
+	if name == p.c.placeholderName && p.placeholder {
+		if !p.consume("::text") {
+			p.consume(" #>> '{}'")
+		}
+		return name, nil
+	}
+	if p.consume("->>'") || p.consume("->'") {
+		if name != p.c.nestedColumn {
+			return "", fmt.Errorf("unknown nested column: %s", name)
+		}
+		key, err := p.until("'")
+		if err != nil {
+			return "", err
+		}
+		return p.field(key)
+	}
+	if p.c.isNestedColumn(name) {
+		return "", fmt.Errorf("column %s isn't a nested field or an exemption", name)
+	}
+	return p.field(name)
+}
+
+func (p *conditionParser) field(name string) (string, error) {
+	if !isValidPostgresIdentifier(name) {
+		return "", fmt.Errorf("invalid column name: %s", name)
+	}
+	if !p.c.isColumnAllowed(name) {
+		return "", ColumnNotAllowedError{Column: name}
+	}
+	return name, nil
+}
+
+// param parses a parameter like $1, $1::jsonb or $1::jsonpath and returns its value in the filter.
+func (p *conditionParser) param() (any, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	start := p.pos
+	for p.pos < len(p.sql) && p.sql[p.pos] >= '0' && p.sql[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.sql[start:p.pos])
+	if err != nil {
+		return nil, p.unsupported()
+	}
+	i := n - p.paramIndex
+	if i < 0 || i >= len(p.values) {
+		return nil, fmt.Errorf("no value for parameter $%d", n)
+	}
+	if p.consume("::jsonpath") {
+		path, ok := p.values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for parameter $%d (must be a jsonpath): %v", n, p.values[i])
+		}
+		return jsonPathValue(path), nil
+	}
+	if p.consume("::jsonb") {
+		// See compareComposite.
+		var doc string
+		switch v := p.values[i].(type) {
+		case string:
+			doc = v
+		case []byte:
+			doc = string(v)
+		default:
+			return nil, fmt.Errorf("invalid value for parameter $%d (must be JSON): %v", n, p.values[i])
+		}
+		if !json.Valid([]byte(doc)) {
+			return nil, fmt.Errorf("invalid value for parameter $%d (must be JSON): %v", n, doc)
+		}
+		return json.RawMessage(doc), nil
+	}
+	return filterValue(p.values[i])
+}
+
+// jsonPathValue is the value of a parameter cast to jsonpath, which isn't a value in the filter.
+type jsonPathValue string
+
+// filterValue converts a value bound by Convert into the value in the filter, using
+// Extended JSON for dates, UUIDs and decimals (see normalizeValue).
+func filterValue(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, string, json.Number, json.RawMessage:
+		return v, nil
+	case time.Time:
+		return map[string]any{"$date": v.Format(time.RFC3339Nano)}, nil
+	case UUID:
+		return map[string]any{"$uuid": string(v)}, nil
+	case Decimal:
+		return map[string]any{"$numberDecimal": string(v)}, nil
+	}
+	if isNumeric(v) {
+		return v, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		result := make([]any, rv.Len())
+		for i := range result {
+			var err error
+			if result[i], err = filterValue(rv.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported value: %v (%T)", v, v)
+}
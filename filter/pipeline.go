@@ -0,0 +1,429 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ConvertPipeline converts a MongoDB aggregation pipeline into a SELECT statement on the table
+// declared with [WithTable], for example:
+//
+//	[{"$match": {"mode": "ranked"}}, {"$group": {"_id": "$map", "players": {"$avg": "$playerCount"}}}, {"$sort": {"players": -1}}, {"$limit": 10}]
+//
+// becomes:
+//
+//	SELECT "meta"->>'map' AS "_id", avg(("meta"->>'playerCount')::numeric) AS "players" FROM "lobbies" WHERE ("meta"->>'mode' = $1) GROUP BY "meta"->>'map' ORDER BY "players" DESC NULLS LAST LIMIT $2
+//
+// Supported stages are $match, $group (with $sum, $avg, $min, $max, $count and $push), $sort,
+// $limit, $skip, $project and $count. Stages that can't be combined in one SELECT are wrapped in
+// a subquery. Fields of the table are checked with the access options, after a $group or
+// $count only the fields they output can be used.
+func (c *Converter) ConvertPipeline(pipeline []byte, startAtParameterIndex int) (query string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+	if c.table == "" {
+		return "", nil, fmt.Errorf("ConvertPipeline requires the table declared with WithTable")
+	}
+	if !isValidPostgresIdentifier(c.table) {
+		return "", nil, fmt.Errorf("invalid table name: %s", c.table)
+	}
+
+	var stages []map[string]json.RawMessage
+	if err := decodeJSON(bytes.NewReader(pipeline), &stages); err != nil {
+		return "", nil, fmt.Errorf("invalid pipeline (must be array of stages): %w", err)
+	}
+
+	b := &pipelineBuilder{
+		c:     c,
+		p:     &boundValues{paramIndex: startAtParameterIndex},
+		conv:  c,
+		query: pipelineQuery{from: fmt.Sprintf("%q", c.table)},
+	}
+	for _, stage := range stages {
+		if len(stage) != 1 {
+			return "", nil, fmt.Errorf("invalid pipeline stage (must be object with one stage): %d stages", len(stage))
+		}
+		for name, value := range stage {
+			if err := b.stage(name, value); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	if b.query.limit == 0 {
+		b.query.limit = c.maxLimit
+	}
+	return b.query.sql(b.p), b.p.values, nil
+}
+
+// pipelineQuery is the SELECT statement of one or more pipeline stages.
+type pipelineQuery struct {
+	// selectList is empty for SELECT *.
+	selectList string
+	from       string
+	where      []string
+	groupBy    string
+	orderBy    string
+	limit      int64
+	offset     int64
+}
+
+// sql returns the SELECT statement, the limit and offset are bound to p.
+func (q pipelineQuery) sql(p *boundValues) string {
+	selectList := q.selectList
+	if selectList == "" {
+		selectList = "*"
+	}
+	sql := "SELECT " + selectList + " FROM " + q.from
+	if len(q.where) > 0 {
+		sql += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	if q.groupBy != "" {
+		sql += " GROUP BY " + q.groupBy
+	}
+	if q.orderBy != "" {
+		sql += " ORDER BY " + q.orderBy
+	}
+	if q.limit > 0 {
+		sql += " LIMIT " + p.add(q.limit)
+	}
+	if q.offset > 0 {
+		sql += " OFFSET " + p.add(q.offset)
+	}
+	return sql
+}
+
+// pipelineBuilder combines the stages of ConvertPipeline into a query.
+type pipelineBuilder struct {
+	c *Converter
+	p *boundValues
+	// conv converts the fields of the current stage: c for the fields of the table, or a
+	// converter for the fields that are the output of a $group or $count stage.
+	conv  *Converter
+	query pipelineQuery
+	// projected is true after a $project stage. Only $sort, $limit and $skip can follow it, as
+	// other stages would need the projected fields.
+	projected  bool
+	subqueries int
+}
+
+// wrap turns the current query into a subquery, so the next stage is applied to its result.
+func (b *pipelineBuilder) wrap(stage string) error {
+	if b.projected {
+		return fmt.Errorf("%s stage after $project not supported", stage)
+	}
+	b.subqueries++
+	b.query = pipelineQuery{from: fmt.Sprintf("(%s) AS stage%d", b.query.sql(b.p), b.subqueries)}
+	return nil
+}
+
+// stage converts a pipeline stage.
+func (b *pipelineBuilder) stage(name string, value json.RawMessage) error {
+	q := &b.query
+	switch name {
+	case "$match":
+		var mongoFilter map[string]any
+		if err := decodeJSON(bytes.NewReader(value), &mongoFilter); err != nil {
+			return fmt.Errorf("invalid value for $match stage (must be object): %w", err)
+		}
+		if len(mongoFilter) == 0 {
+			// Unlike an empty filter in Convert, an empty $match matches all documents.
+			return nil
+		}
+		if q.selectList != "" || q.limit > 0 || q.offset > 0 {
+			if err := b.wrap(name); err != nil {
+				return err
+			}
+		}
+		conditions, values, err := b.conv.ConvertMap(mongoFilter, b.paramIndex())
+		if err != nil {
+			return err
+		}
+		b.p.values = append(b.p.values, values...)
+		b.query.where = append(b.query.where, conditions)
+	case "$sort":
+		if q.limit > 0 || q.offset > 0 {
+			if err := b.wrap(name); err != nil {
+				return err
+			}
+		}
+		orderBy, values, err := b.conv.convertOrderBy(value, nil, b.paramIndex())
+		if err != nil {
+			return err
+		}
+		if orderBy == "" {
+			return fmt.Errorf("invalid value for $sort stage (must be non-empty object): %s", value)
+		}
+		b.p.values = append(b.p.values, values...)
+		b.query.orderBy = orderBy
+	case "$limit":
+		limit, err := stageNumber(name, value)
+		if err != nil {
+			return err
+		}
+		if limit == 0 {
+			return fmt.Errorf("invalid value for $limit stage (must be a positive integer): %s", value)
+		}
+		if b.c.maxLimit > 0 && limit > b.c.maxLimit {
+			return fmt.Errorf("invalid value for $limit stage (must be at most %d): %d", b.c.maxLimit, limit)
+		}
+		if q.limit == 0 || limit < q.limit {
+			q.limit = limit
+		}
+	case "$skip":
+		skip, err := stageNumber(name, value)
+		if err != nil {
+			return err
+		}
+		if q.limit > 0 {
+			if err := b.wrap(name); err != nil {
+				return err
+			}
+		}
+		b.query.offset += skip
+	case "$group":
+		if q.selectList != "" || q.orderBy != "" || q.limit > 0 || q.offset > 0 {
+			if err := b.wrap(name); err != nil {
+				return err
+			}
+		}
+		return b.group(value)
+	case "$project":
+		if q.selectList != "" {
+			if err := b.wrap(name); err != nil {
+				return err
+			}
+		}
+		selectList, err := b.conv.convertProjection(value)
+		if err != nil {
+			return err
+		}
+		if selectList != "*" {
+			b.query.selectList = selectList
+			b.projected = true
+		}
+	case "$count":
+		var field string
+		if err := json.Unmarshal(value, &field); err != nil || !isValidPostgresIdentifier(field) {
+			return fmt.Errorf("invalid value for $count stage (must be a field name): %s", value)
+		}
+		if q.selectList != "" || q.orderBy != "" || q.limit > 0 || q.offset > 0 {
+			if err := b.wrap(name); err != nil {
+				return err
+			}
+		}
+		b.query.selectList = fmt.Sprintf("count(*) AS %q", field)
+		b.conv = b.outputConverter([]string{field})
+	default:
+		return fmt.Errorf("unsupported pipeline stage: %s", name)
+	}
+	return nil
+}
+
+// group converts a $group stage like {"_id": "$map", "total": {"$sum": "$playerCount"}}.
+// An _id object like {"map": "$map", "mode": "$mode"} groups on multiple fields, which are
+// output as separate fields. With a null _id all rows are one group.
+func (b *pipelineBuilder) group(value json.RawMessage) error {
+	fields, err := objectInOrder(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for $group stage (must be object): %w", err)
+	}
+
+	var selectList, groupBy, outputs []string
+	output := func(name, expression string) error {
+		if !isValidPostgresIdentifier(name) {
+			return fmt.Errorf("invalid $group field name: %s", name)
+		}
+		for _, o := range outputs {
+			if o == name {
+				return fmt.Errorf("duplicate $group field name: %s", name)
+			}
+		}
+		outputs = append(outputs, name)
+		selectList = append(selectList, fmt.Sprintf("%s AS %q", expression, name))
+		return nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := decodeJSON(bytes.NewReader(value), &raw); err != nil {
+		return fmt.Errorf("invalid value for $group stage (must be object): %w", err)
+	}
+	id, hasID := raw["_id"]
+	if hasID {
+		var keys []struct {
+			Key   string
+			Value any
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(id), []byte("{")) {
+			if keys, err = objectInOrder(id); err != nil {
+				return err
+			}
+		} else if !bytes.Equal(id, []byte("null")) {
+			var v any
+			if err := decodeJSON(bytes.NewReader(id), &v); err != nil {
+				return err
+			}
+			keys = append(keys, struct {
+				Key   string
+				Value any
+			}{"_id", v})
+		}
+		for _, key := range keys {
+			expression, err := b.groupField(key.Value, "")
+			if err != nil {
+				return err
+			}
+			if err := output(key.Key, expression); err != nil {
+				return err
+			}
+			groupBy = append(groupBy, expression)
+		}
+	}
+	if !hasID {
+		return fmt.Errorf("$group stage requires an _id field")
+	}
+
+	for _, kv := range fields {
+		if kv.Key == "_id" {
+			continue
+		}
+		expression, err := b.accumulator(kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+		if err := output(kv.Key, expression); err != nil {
+			return err
+		}
+	}
+
+	b.query.selectList = strings.Join(selectList, ", ")
+	b.query.groupBy = strings.Join(groupBy, ", ")
+	b.conv = b.outputConverter(outputs)
+	return nil
+}
+
+// accumulator converts a $group accumulator like {"$sum": "$playerCount"}.
+func (b *pipelineBuilder) accumulator(name string, value any) (string, error) {
+	v, ok := value.(map[string]any)
+	if !ok || len(v) != 1 {
+		return "", fmt.Errorf("invalid value for $group field %s (must be object with one accumulator): %v", name, value)
+	}
+	for operator, arg := range v {
+		switch operator {
+		case "$count":
+			if args, ok := arg.(map[string]any); !ok || len(args) != 0 {
+				return "", fmt.Errorf("invalid value for $count accumulator (must be empty object): %v", arg)
+			}
+			return "count(*)", nil
+		case "$sum":
+			if isNumeric(arg) {
+				arg, err := normalizeValue(arg)
+				if err != nil {
+					return "", err
+				}
+				// {"$sum": 1} counts the rows of the group.
+				if numberEquals(arg, 1) {
+					return "count(*)", nil
+				}
+				return fmt.Sprintf("sum(%s::numeric)", b.p.add(arg)), nil
+			}
+			expression, err := b.groupField(arg, "numeric")
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("sum(%s)", expression), nil
+		case "$avg", "$min", "$max":
+			cast := "numeric"
+			if field, ok := arg.(string); ok && b.conv.fieldTypes[strings.TrimPrefix(field, "$")] == FieldTypeTimestamp && operator != "$avg" {
+				cast = "timestamptz"
+			}
+			expression, err := b.groupField(arg, cast)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s(%s)", strings.TrimPrefix(operator, "$"), expression), nil
+		case "$push":
+			expression, err := b.groupField(arg, "jsonb")
+			if err != nil {
+				return "", err
+			}
+			field := strings.TrimPrefix(arg.(string), "$")
+			if b.conv.isNestedColumn(field) {
+				return fmt.Sprintf("jsonb_agg(%s)", expression), nil
+			}
+			return fmt.Sprintf("array_agg(%s)", expression), nil
+		default:
+			return "", fmt.Errorf("unsupported $group accumulator: %s", operator)
+		}
+	}
+	return "", nil
+}
+
+// groupField returns the expression of a field reference like "$playerCount". Fields in the
+// nested JSONB column are cast to cast, as text if it's empty or as JSONB if it's jsonb.
+func (b *pipelineBuilder) groupField(value any, cast string) (string, error) {
+	field, ok := value.(string)
+	if !ok || !strings.HasPrefix(field, "$") {
+		return "", fmt.Errorf("invalid $group field reference (must be a string like \"$field\"): %v", value)
+	}
+	field = field[1:]
+	if !isValidPostgresIdentifier(field) {
+		return "", fmt.Errorf("invalid column name: %s", field)
+	}
+	if !b.conv.isColumnAllowed(field) {
+		return "", ColumnNotAllowedError{Column: field}
+	}
+	if !b.conv.isNestedColumn(field) {
+		return b.conv.columnName(field, true), nil
+	}
+	switch cast {
+	case "":
+		return b.conv.columnName(field, true), nil
+	case "jsonb":
+		return b.conv.columnName(field, false), nil
+	default:
+		return b.conv.castColumn(field, cast), nil
+	}
+}
+
+// outputConverter returns the converter for the fields that are the output of a stage. These
+// are regular columns of a subquery, and they are all allowed.
+func (b *pipelineBuilder) outputConverter(fields []string) *Converter {
+	c := &Converter{
+		allowedColumns:  fields,
+		knownColumns:    fields,
+		arrayDriver:     b.c.arrayDriver,
+		emptyCondition:  b.c.emptyCondition,
+		placeholderName: b.c.placeholderName,
+		implicitArrays:  b.c.implicitArrays,
+		mongoNulls:      b.c.mongoNulls,
+		existsPolicy:    b.c.existsPolicy,
+		defaultNulls:    b.c.defaultNulls,
+		collation:       b.c.collation,
+		collations:      b.c.collations,
+	}
+	c.setDefaults()
+	return c
+}
+
+func (b *pipelineBuilder) paramIndex() int {
+	return b.p.paramIndex + len(b.p.values)
+}
+
+// stageNumber returns the non-negative integer value of a $limit or $skip stage.
+func stageNumber(name string, value json.RawMessage) (int64, error) {
+	var n json.Number
+	if err := json.Unmarshal(value, &n); err != nil {
+		return 0, fmt.Errorf("invalid value for %s stage (must be a non-negative integer): %s", name, value)
+	}
+	i, err := n.Int64()
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value for %s stage (must be a non-negative integer): %s", name, value)
+	}
+	return i, nil
+}
@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConvertProjection converts a MongoDB projection into a SELECT list, for example:
+//
+//	{"name": 1, "metadata.pet": 1, "_id": 0} -> "name", "metadata"->'pet' AS "pet"
+//
+// Fields in the nested JSONB column are selected as a column with their name, they can
+// also be referenced through the nested column like "metadata.pet". As there is no _id
+// column, excluding _id is ignored.
+//
+// Exclusion projections like {"password": 0} select all columns declared with
+// [WithKnownColumns] except the excluded ones, and remove excluded fields from the
+// nested JSONB column. Columns that aren't allowed are never selected.
+func (c *Converter) ConvertProjection(projection []byte) (string, error) {
+	c.setDefaults()
+
+	return c.convertProjection(projection)
+}
+
+// projectionField is a field of a projection, nested is true for fields in the nested JSONB column.
+type projectionField struct {
+	name   string
+	nested bool
+}
+
+func (c *Converter) convertProjection(projection []byte) (string, error) {
+	fields, err := objectInOrder(projection)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for projection (must be object): %w", err)
+	}
+
+	var included, excluded []projectionField
+	for _, kv := range fields {
+		key, value := kv.Key, kv.Value
+		value, err := normalizeValue(value)
+		if err != nil {
+			return "", err
+		}
+		var include bool
+		switch {
+		case value == true || numberEquals(value, 1):
+			include = true
+		case value == false || numberEquals(value, 0):
+			include = false
+		default:
+			return "", fmt.Errorf("invalid value for projection field %s: %v (must be 1, 0, true or false)", key, value)
+		}
+		if key == "_id" && !include {
+			continue
+		}
+
+		var field projectionField
+		switch {
+		case c.nestedColumn != "" && strings.HasPrefix(key, c.nestedColumn+"."):
+			// metadata.pet is the same as pet.
+			field = projectionField{name: strings.TrimPrefix(key, c.nestedColumn+"."), nested: true}
+		case c.nestedColumn != "" && key == c.nestedColumn:
+			field = projectionField{name: key}
+		default:
+			field = projectionField{name: key, nested: c.isNestedColumn(key)}
+		}
+		if !isValidPostgresIdentifier(field.name) {
+			return "", fmt.Errorf("invalid column name: %s", key)
+		}
+		if include {
+			included = append(included, field)
+		} else {
+			excluded = append(excluded, field)
+		}
+	}
+	if len(included) > 0 && len(excluded) > 0 {
+		return "", fmt.Errorf("projection can't both include and exclude fields")
+	}
+
+	if len(excluded) > 0 {
+		return c.exclusionProjection(excluded)
+	}
+	if len(included) == 0 {
+		return "*", nil
+	}
+	columns := make([]string, 0, len(included))
+	for _, field := range included {
+		if !c.isColumnAllowed(field.name) {
+			return "", ColumnNotAllowedError{Column: field.name}
+		}
+		if field.nested {
+			columns = append(columns, fmt.Sprintf("%q->'%s' AS %q", c.nestedColumn, field.name, field.name))
+		} else {
+			columns = append(columns, fmt.Sprintf("%q", field.name))
+		}
+	}
+	return strings.Join(columns, ", "), nil
+}
+
+// exclusionProjection returns the SELECT list of all known columns except the excluded fields.
+func (c *Converter) exclusionProjection(excluded []projectionField) (string, error) {
+	if c.knownColumns == nil {
+		return "", fmt.Errorf("exclusion projections require the columns declared with WithKnownColumns")
+	}
+	isExcluded := map[string]bool{}
+	var nestedFields []string
+	for _, field := range excluded {
+		if field.nested {
+			nestedFields = append(nestedFields, field.name)
+		} else {
+			isExcluded[field.name] = true
+		}
+	}
+
+	columns := []string{}
+	nestedKnown := false
+	for _, column := range c.knownColumns {
+		if column == c.nestedColumn {
+			nestedKnown = true
+		}
+		if isExcluded[column] || !isValidPostgresIdentifier(column) || !c.isColumnAllowed(column) {
+			continue
+		}
+		if column == c.nestedColumn && len(nestedFields) > 0 {
+			// The - operator removes keys from a JSONB object.
+			expression := fmt.Sprintf("%q", column)
+			for _, field := range nestedFields {
+				expression += fmt.Sprintf(" - '%s'", field)
+			}
+			columns = append(columns, fmt.Sprintf("%s AS %q", expression, column))
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("%q", column))
+	}
+	if len(nestedFields) > 0 && !nestedKnown {
+		// The exclusion would silently be ignored otherwise.
+		return "", fmt.Errorf("excluding nested fields requires the nested column %s declared with WithKnownColumns", c.nestedColumn)
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("projection excludes all columns")
+	}
+	return strings.Join(columns, ", "), nil
+}
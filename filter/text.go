@@ -0,0 +1,85 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultTextSearchLanguage is the text search configuration used when $text has no $language.
+// This is the same default as MongoDB uses.
+const defaultTextSearchLanguage = "english"
+
+// textSearch is a $text operator, see [WithTextSearch].
+type textSearch struct {
+	search   string
+	language string
+}
+
+// parseTextSearch parses the value of a $text operator, for example:
+//
+//	{"$search": "dragon rider", "$language": "english"}
+func (c *Converter) parseTextSearch(value any) (textSearch, error) {
+	if len(c.textSearchColumns) == 0 && c.textSearchVector == "" {
+		return textSearch{}, fmt.Errorf("$text operator not supported (no text search columns configured)")
+	}
+	v, ok := value.(map[string]any)
+	if !ok {
+		return textSearch{}, fmt.Errorf("invalid value for $text operator (must be object): %v", value)
+	}
+	t := textSearch{language: defaultTextSearchLanguage}
+	for key, option := range v {
+		switch key {
+		case "$search":
+			search, ok := option.(string)
+			if !ok {
+				return textSearch{}, fmt.Errorf("invalid value for $search (must be string): %v", option)
+			}
+			t.search = search
+		case "$language":
+			language, ok := option.(string)
+			if !ok || !isValidPostgresIdentifier(language) {
+				return textSearch{}, fmt.Errorf("invalid value for $language: %v", option)
+			}
+			t.language = strings.ToLower(language)
+			if t.language == "none" {
+				// MongoDB uses "none" for no stemming and stop words, which is "simple" in Postgres.
+				t.language = "simple"
+			}
+		case "$caseSensitive", "$diacriticSensitive":
+			if option != false {
+				return textSearch{}, fmt.Errorf("%s not supported", key)
+			}
+		default:
+			return textSearch{}, fmt.Errorf("unknown $text option: %s", key)
+		}
+	}
+	if _, ok := v["$search"]; !ok {
+		return textSearch{}, fmt.Errorf("$text operator requires $search")
+	}
+	return t, nil
+}
+
+// textSearchDocument returns the tsvector to search in with language.
+func (c *Converter) textSearchDocument(language string) string {
+	if c.textSearchVector != "" {
+		return fmt.Sprintf("%q", c.textSearchVector)
+	}
+	if len(c.textSearchColumns) == 1 {
+		return fmt.Sprintf("to_tsvector('%s', %s)", language, c.columnName(c.textSearchColumns[0], true))
+	}
+	columns := make([]string, 0, len(c.textSearchColumns))
+	for _, column := range c.textSearchColumns {
+		columns = append(columns, fmt.Sprintf("coalesce(%s, '')", c.columnName(column, true)))
+	}
+	return fmt.Sprintf("to_tsvector('%s', %s)", language, strings.Join(columns, " || ' ' || "))
+}
+
+// condition returns the condition matching the text search, with the search bound as $paramIndex.
+func (t textSearch) condition(c *Converter, paramIndex int) string {
+	return fmt.Sprintf("(%s @@ websearch_to_tsquery('%s', $%d))", c.textSearchDocument(t.language), t.language, paramIndex)
+}
+
+// rank returns the ts_rank of the text search, with the search bound as $paramIndex.
+func (t textSearch) rank(c *Converter, paramIndex int) string {
+	return fmt.Sprintf("ts_rank(%s, websearch_to_tsquery('%s', $%d))", c.textSearchDocument(t.language), t.language, paramIndex)
+}
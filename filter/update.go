@@ -0,0 +1,433 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConvertUpdate converts a MongoDB update document into the assignments of an UPDATE
+// statement, for example:
+//
+//	{"$set": {"name": "John"}, "$inc": {"level": 1}, "$push": {"pets": "dog"}}
+//
+// becomes:
+//
+//	"level" = COALESCE("level", 0) + $1, "name" = $2, "meta" = jsonb_set(COALESCE("meta", '{}'), '{pets}', COALESCE("meta"->'pets', '[]') || $3::jsonb)
+//
+// Supported operators are $set, $unset, $inc, $mul, $min, $max, $push, $addToSet, $pull,
+// $currentDate and $rename. Fields in the nested JSONB column are updated with jsonb_set
+// and #-, other columns are assigned directly. Only the columns declared with
+// [WithWritableColumns] can be updated.
+func (c *Converter) ConvertUpdate(update []byte, startAtParameterIndex int) (set string, values []any, err error) {
+	c.setDefaults()
+
+	if startAtParameterIndex < 1 {
+		return "", nil, fmt.Errorf("startAtParameterIndex must be greater than 0")
+	}
+	if c.writableColumns == nil {
+		return "", nil, fmt.Errorf("ConvertUpdate requires the columns declared with WithWritableColumns")
+	}
+
+	var operators map[string]any
+	if err := decodeJSON(bytes.NewReader(update), &operators); err != nil {
+		return "", nil, err
+	}
+	if len(operators) == 0 {
+		return "", nil, fmt.Errorf("empty objects not allowed")
+	}
+
+	u := &updateBuilder{
+		c:      c,
+		p:      &boundValues{paramIndex: startAtParameterIndex},
+		fields: map[string]bool{},
+	}
+	keys := make([]string, 0, len(operators))
+	for operator := range operators {
+		keys = append(keys, operator)
+	}
+	sort.Strings(keys)
+	for _, operator := range keys {
+		fields, ok := operators[operator].(map[string]any)
+		if !ok || len(fields) == 0 {
+			if !strings.HasPrefix(operator, "$") {
+				return "", nil, fmt.Errorf("update must only contain update operators: %s", operator)
+			}
+			return "", nil, fmt.Errorf("invalid value for %s operator (must be non-empty object): %v", operator, operators[operator])
+		}
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		for _, field := range names {
+			if err := u.update(operator, field, fields[field]); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	assignments := u.assignments
+	if u.nested != "" {
+		assignments = append(assignments, fmt.Sprintf("%q = %s", c.nestedColumn, u.nested))
+	}
+	return strings.Join(assignments, ", "), u.p.values, nil
+}
+
+// updateBuilder collects the assignments of ConvertUpdate. All updates of fields in the nested
+// JSONB column are combined in nested, as a column can only be assigned once.
+type updateBuilder struct {
+	c           *Converter
+	p           *boundValues
+	assignments []string
+	nested      string
+	fields      map[string]bool
+}
+
+// update converts the update operator on field.
+func (u *updateBuilder) update(operator, field string, value any) error {
+	c := u.c
+	if err := u.writable(field); err != nil {
+		return err
+	}
+
+	value, err := normalizeValue(value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case field == c.nestedColumn:
+		// The nested JSONB column itself is assigned like other columns, which can't be combined
+		// with updates of its fields.
+		if u.nested != "" {
+			return fmt.Errorf("conflicting update operators for field: %s", field)
+		}
+		return u.updateColumn(operator, field, value)
+	case !c.isNestedColumn(field):
+		return u.updateColumn(operator, field, value)
+	case u.fields[c.nestedColumn]:
+		return fmt.Errorf("conflicting update operators for field: %s", c.nestedColumn)
+	default:
+		return u.updateNested(operator, field, value)
+	}
+}
+
+// writable checks if field can be updated, and isn't updated by another operator.
+func (u *updateBuilder) writable(field string) error {
+	if !isValidPostgresIdentifier(field) {
+		return fmt.Errorf("invalid column name: %s", field)
+	}
+	if !u.c.isColumnWritable(field) {
+		return ColumnNotWritableError{Column: field}
+	}
+	// Like MongoDB, updating a field with multiple operators is a conflict.
+	if u.fields[field] {
+		return fmt.Errorf("conflicting update operators for field: %s", field)
+	}
+	u.fields[field] = true
+	return nil
+}
+
+// updateColumn converts an update operator on a column that isn't in the nested JSONB column.
+func (u *updateBuilder) updateColumn(operator, column string, value any) error {
+	c := u.c
+	quoted := fmt.Sprintf("%q", column)
+
+	var expression string
+	switch operator {
+	case "$set":
+		columnValue := u.columnValue
+		if column == c.nestedColumn {
+			columnValue = u.jsonbValue
+		}
+		v, err := columnValue(column, value)
+		if err != nil {
+			return err
+		}
+		expression = v
+	case "$unset":
+		expression = "NULL"
+	case "$inc", "$mul":
+		if !isNumeric(value) {
+			return fmt.Errorf("invalid value for %s operator (must be a number): %v", operator, value)
+		}
+		op := "+"
+		if operator == "$mul" {
+			op = "*"
+		}
+		// Like in MongoDB a missing (NULL) value is seen as 0.
+		expression = fmt.Sprintf("COALESCE(%s, 0) %s %s", quoted, op, u.p.add(value))
+	case "$min", "$max":
+		if !isScalar(value) || value == nil {
+			return fmt.Errorf("invalid value for %s operator (must be a primitive): %v", operator, value)
+		}
+		value, err := c.fieldValue(column, value)
+		if err != nil {
+			return err
+		}
+		// LEAST and GREATEST ignore NULL, so a missing value is set.
+		function := "LEAST"
+		if operator == "$max" {
+			function = "GREATEST"
+		}
+		expression = fmt.Sprintf("%s(%s, %s)", function, quoted, u.p.add(value))
+	case "$push", "$addToSet":
+		elements, each, err := updateElements(operator, value)
+		if err != nil {
+			return err
+		}
+		if !isScalarSlice(elements) {
+			return fmt.Errorf("invalid value for %s operator (must be primitives): %v", operator, value)
+		}
+		if operator == "$addToSet" {
+			elements = uniqueElements(elements)
+		}
+		switch {
+		case !each && operator == "$push":
+			expression = fmt.Sprintf("array_append(%s, %s)", quoted, u.p.add(elements[0]))
+		case !each:
+			placeholder := u.p.add(elements[0])
+			expression = fmt.Sprintf("(CASE WHEN %s = ANY(%s) THEN %s ELSE array_append(%s, %s) END)", placeholder, quoted, quoted, quoted, placeholder)
+		case operator == "$push":
+			expression = fmt.Sprintf("%s || %s", quoted, u.p.add(c.arrayValue(elements)))
+		default:
+			// This appends the elements that aren't in the array yet, in their order:
+			//
+			//   COALESCE("tags", '{}') || ARRAY(SELECT __filter_placeholder FROM unnest(COALESCE("tags", '{}') || $1) WITH ORDINALITY AS __filter_placeholder
+			//     WHERE ordinality > cardinality(COALESCE("tags", '{}')) AND __filter_placeholder <> ALL(COALESCE("tags", '{}')) ORDER BY ordinality)
+			//
+			// unnest can't be used on $1 directly, as Postgres can't infer its type.
+			current := fmt.Sprintf("COALESCE(%s, '{}')", quoted)
+			expression = fmt.Sprintf("%s || ARRAY(SELECT %s FROM unnest(%s || %s) WITH ORDINALITY AS %s WHERE ordinality > cardinality(%s) AND %s <> ALL(%s) ORDER BY ordinality)",
+				current, c.placeholderName, current, u.p.add(c.arrayValue(elements)), c.placeholderName, current, c.placeholderName, current)
+		}
+	case "$pull":
+		if isScalar(value) && value != nil {
+			expression = fmt.Sprintf("array_remove(%s, %s)", quoted, u.p.add(value))
+			break
+		}
+		// Conditions like {"$gte": 6} work like $elemMatch.
+		condition, conditionValues, err := c.convertFilter(map[string]any{c.placeholderName: value}, u.p.paramIndex+len(u.p.values), false)
+		if err != nil {
+			return err
+		}
+		u.p.values = append(u.p.values, conditionValues...)
+		expression = fmt.Sprintf("ARRAY(SELECT %s FROM unnest(%s) AS %s WHERE NOT COALESCE(%s, FALSE))", c.placeholderName, quoted, c.placeholderName, condition)
+	case "$currentDate":
+		if err := currentDate(value); err != nil {
+			return err
+		}
+		expression = "CURRENT_TIMESTAMP"
+	case "$rename":
+		to, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid value for $rename operator (must be string): %v", value)
+		}
+		if err := u.writable(to); err != nil {
+			return err
+		}
+		if c.isNestedColumn(to) {
+			return fmt.Errorf("$rename between the nested JSONB column and other columns not supported: %s", column)
+		}
+		// Columns can't be renamed, so the value is moved to the other column.
+		u.assignments = append(u.assignments, fmt.Sprintf("%q = %s", to, quoted))
+		expression = "NULL"
+	default:
+		return fmt.Errorf("unknown update operator: %s", operator)
+	}
+
+	u.assignments = append(u.assignments, fmt.Sprintf("%s = %s", quoted, expression))
+	return nil
+}
+
+// updateNested converts an update operator on a field in the nested JSONB column.
+func (u *updateBuilder) updateNested(operator, field string, value any) error {
+	c := u.c
+	if u.nested == "" {
+		u.nested = fmt.Sprintf("COALESCE(%q, '{}')", c.nestedColumn)
+	}
+	// Every field is only updated once, so the current value can be read from the column.
+	jsonb := c.columnName(field, false)
+	path := fmt.Sprintf("'{%s}'", field)
+
+	var newValue string
+	switch operator {
+	case "$set":
+		v, err := u.jsonbValue(field, value)
+		if err != nil {
+			return err
+		}
+		newValue = v
+	case "$unset":
+		u.nested = fmt.Sprintf("%s #- %s", u.nested, path)
+		return nil
+	case "$inc", "$mul":
+		if !isNumeric(value) {
+			return fmt.Errorf("invalid value for %s operator (must be a number): %v", operator, value)
+		}
+		op := "+"
+		if operator == "$mul" {
+			op = "*"
+		}
+		newValue = fmt.Sprintf("to_jsonb(COALESCE((%s)::numeric, 0) %s %s::numeric)", c.columnName(field, true), op, u.p.add(value))
+	case "$min", "$max":
+		if !isNumeric(value) {
+			return fmt.Errorf("invalid value for %s operator on nested jsonb fields (must be a number): %v", operator, value)
+		}
+		function := "LEAST"
+		if operator == "$max" {
+			function = "GREATEST"
+		}
+		newValue = fmt.Sprintf("to_jsonb(%s((%s)::numeric, %s::numeric))", function, c.columnName(field, true), u.p.add(value))
+	case "$push", "$addToSet":
+		elements, _, err := updateElements(operator, value)
+		if err != nil {
+			return err
+		}
+		if operator == "$addToSet" {
+			elements = uniqueElements(elements)
+		}
+		doc, err := jsonbValue(elements)
+		if err != nil {
+			return err
+		}
+		current := fmt.Sprintf("COALESCE(%s, '[]')", jsonb)
+		if operator == "$push" {
+			newValue = fmt.Sprintf("%s || %s::jsonb", current, u.p.add(doc))
+			break
+		}
+		// This appends the elements that aren't in the array yet, in their order.
+		newValue = fmt.Sprintf("%s || COALESCE((SELECT jsonb_agg(%s ORDER BY ordinality) FROM jsonb_array_elements(%s::jsonb) WITH ORDINALITY AS %s WHERE %s <> ALL(ARRAY(SELECT jsonb_array_elements(%s)))), '[]')",
+			current, c.placeholderName, u.p.add(doc), c.placeholderName, c.placeholderName, current)
+	case "$pull":
+		var condition string
+		if isScalar(value) || isComposite(value) {
+			doc, err := jsonbValue(value)
+			if err != nil {
+				return err
+			}
+			condition = fmt.Sprintf("%s = %s::jsonb", c.placeholderName, u.p.add(doc))
+		} else {
+			// Conditions like {"$gte": 6} work like $elemMatch.
+			var conditionValues []any
+			var err error
+			condition, conditionValues, err = c.convertFilter(map[string]any{c.placeholderName: value}, u.p.paramIndex+len(u.p.values), true)
+			if err != nil {
+				return err
+			}
+			u.p.values = append(u.p.values, conditionValues...)
+		}
+		// jsonb_set returns NULL when the new value is NULL, jsonb_set_lax keeps the document
+		// as is when the field isn't an array.
+		u.nested = fmt.Sprintf("jsonb_set_lax(%s, %s, (CASE WHEN jsonb_typeof(%s) = 'array' THEN COALESCE((SELECT jsonb_agg(%s ORDER BY ordinality) FROM jsonb_array_elements(%s) WITH ORDINALITY AS %s WHERE NOT COALESCE(%s, FALSE)), '[]') END), true, 'return_target')",
+			u.nested, path, jsonb, c.placeholderName, jsonb, c.placeholderName, condition)
+		return nil
+	case "$currentDate":
+		if err := currentDate(value); err != nil {
+			return err
+		}
+		newValue = "to_jsonb(CURRENT_TIMESTAMP)"
+	case "$rename":
+		to, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid value for $rename operator (must be string): %v", value)
+		}
+		if err := u.writable(to); err != nil {
+			return err
+		}
+		if !c.isNestedColumn(to) || to == c.nestedColumn {
+			return fmt.Errorf("$rename between the nested JSONB column and other columns not supported: %s", field)
+		}
+		// A missing field isn't renamed, jsonb_set_lax keeps the document as is.
+		u.nested = fmt.Sprintf("jsonb_set_lax(%s #- %s, '{%s}', %s, true, 'return_target')", u.nested, path, to, jsonb)
+		return nil
+	default:
+		return fmt.Errorf("unknown update operator: %s", operator)
+	}
+
+	u.nested = fmt.Sprintf("jsonb_set(%s, %s, %s)", u.nested, path, newValue)
+	return nil
+}
+
+// columnValue binds value for a column that isn't in the nested JSONB column.
+func (u *updateBuilder) columnValue(column string, value any) (string, error) {
+	switch {
+	case isScalar(value):
+		value, err := u.c.fieldValue(column, value)
+		if err != nil {
+			return "", err
+		}
+		return u.p.add(value), nil
+	case isScalarSlice(value):
+		return u.p.add(u.c.arrayValue(value)), nil
+	default:
+		// Embedded documents can only be stored in a JSONB column.
+		doc, err := jsonbValue(value)
+		if err != nil {
+			return "", err
+		}
+		return u.p.add(doc) + "::jsonb", nil
+	}
+}
+
+// jsonbValue binds value for a field in the nested JSONB column.
+func (u *updateBuilder) jsonbValue(field string, value any) (string, error) {
+	value, err := u.c.fieldValue(field, value)
+	if err != nil {
+		return "", err
+	}
+	doc, err := jsonbValue(value)
+	if err != nil {
+		return "", err
+	}
+	return u.p.add(doc) + "::jsonb", nil
+}
+
+// arrayValue returns the value to bind for an array column.
+func (c *Converter) arrayValue(v any) any {
+	if c.arrayDriver != nil {
+		return c.arrayDriver(v)
+	}
+	return v
+}
+
+// updateElements returns the elements to add with $push or $addToSet, which is either a single
+// value or an object with $each. each is true for $each.
+func updateElements(operator string, value any) (elements []any, each bool, err error) {
+	if v, ok := value.(map[string]any); ok && v["$each"] != nil {
+		elements, ok := v["$each"].([]any)
+		if !ok || len(v) != 1 {
+			return nil, false, fmt.Errorf("invalid value for %s operator (must be object with $each array only): %v", operator, value)
+		}
+		return elements, true, nil
+	}
+	return []any{value}, false, nil
+}
+
+// uniqueElements removes duplicates from elements, like $addToSet does.
+func uniqueElements(elements []any) []any {
+	seen := map[string]bool{}
+	unique := make([]any, 0, len(elements))
+	for _, e := range elements {
+		key, err := jsonbValue(e)
+		if err == nil && seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, e)
+	}
+	return unique
+}
+
+// currentDate checks the value of $currentDate, only dates are supported.
+func currentDate(value any) error {
+	if value == true {
+		return nil
+	}
+	if v, ok := value.(map[string]any); ok && len(v) == 1 && v["$type"] == "date" {
+		return nil
+	}
+	return fmt.Errorf("invalid value for $currentDate operator (must be true or {\"$type\": \"date\"}): %v", value)
+}
@@ -4,22 +4,47 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
 )
 
+// boundValues collects the values bound for a condition with a variable number of parameters.
+type boundValues struct {
+	paramIndex int
+	values     []any
+}
+
+// add binds v and returns its placeholder.
+func (p *boundValues) add(v any) string {
+	p.values = append(p.values, v)
+	return fmt.Sprintf("$%d", p.paramIndex+len(p.values)-1)
+}
+
 func isNumeric(v any) bool {
-	// json.Unmarshal returns float64 for all numbers
-	// so we only need to check for float64.
-	_, ok := v.(float64)
-	return ok
+	// We decode with json.Decoder.UseNumber, but filters passed to
+	// ConvertMap can contain any of the Go native number types.
+	switch v.(type) {
+	case json.Number, Decimal, float64, float32,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return true
+	default:
+		return false
+	}
 }
 
 func isScalar(v any) bool {
 	if v == nil {
 		return true
 	}
+	if isNumeric(v) {
+		return true
+	}
 
 	switch v.(type) {
-	case bool, float64, string:
+	case bool, string, time.Time, UUID:
 		return true
 	default:
 		return false
@@ -35,8 +60,72 @@ func isScalarSlice(v any) bool {
 			}
 		}
 		return true
+	case nil:
+		return false
 	default:
+		// Typed slices such as []string or []int64 from ConvertMap.
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Interface {
+			return false
+		}
+		return isScalar(reflect.Zero(rv.Type().Elem()).Interface())
+	}
+}
+
+// parseRegexLiteral parses a regular expression literal like /^bot/i into its pattern and the
+// operator to match it with: ~* for the i flag, ~ otherwise.
+func parseRegexLiteral(s string) (pattern string, op string, err error) {
+	end := strings.LastIndex(s, "/")
+	if !strings.HasPrefix(s, "/") || end < 1 {
+		return "", "", fmt.Errorf("invalid regular expression (must be /pattern/flags): %s", s)
+	}
+	op = "~"
+	for _, flag := range s[end+1:] {
+		if flag != 'i' {
+			return "", "", fmt.Errorf("unsupported regular expression flag: %c", flag)
+		}
+		op = "~*"
+	}
+	return s[1:end], op, nil
+}
+
+// containsNil returns true if v is a slice with a nil element.
+func containsNil(v any) bool {
+	if v, ok := v.([]any); ok {
+		for _, e := range v {
+			if e == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDocument returns true if v is an embedded document to compare with, instead of an
+// object with operators like {"$gt": 1} or {"$field": "name"}.
+func isDocument(v map[string]any) bool {
+	if len(v) == 0 {
+		return false
+	}
+	for key := range v {
+		if strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// isComposite returns true if v is an array or an embedded document, which are compared as a whole.
+func isComposite(v any) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return isDocument(v)
+	case []any:
+		return true
+	case nil:
 		return false
+	default:
+		return reflect.ValueOf(v).Kind() == reflect.Slice
 	}
 }
 
@@ -53,6 +142,8 @@ func anyToSliceMapAny(v any) ([]map[string]any, bool) {
 			}
 		}
 		return result, true
+	case []map[string]any:
+		return v, true
 	default:
 		return nil, false
 	}
@@ -78,11 +169,29 @@ func isValidPostgresIdentifier(s string) bool {
 	return true
 }
 
+// decodeJSON decodes a single JSON value from r into v, keeping numbers as json.Number.
+// Like json.Unmarshal it doesn't allow any data after the value.
+func decodeJSON(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after top-level value")
+	}
+	return nil
+}
+
+// objectInOrder decodes the JSON object b into its keys and values, in the order of b.
+// Numbers are decoded as json.Number, see decodeJSON.
 func objectInOrder(b []byte) ([]struct {
 	Key   string
 	Value any
 }, error) {
 	dec := json.NewDecoder(bytes.NewReader(b))
+	// Like decodeJSON, keep numbers as json.Number so normalizeValue doesn't lose precision.
+	dec.UseNumber()
 
 	// expect {
 	tok, err := dec.Token()
@@ -129,3 +238,9 @@ func objectInOrder(b []byte) ([]struct {
 
 	return result, nil
 }
+
+// quoteCollation quotes a collation name, which can contain characters like - and . that
+// aren't allowed in unquoted identifiers.
+func quoteCollation(collation string) string {
+	return `"` + strings.ReplaceAll(collation, `"`, `""`) + `"`
+}
@@ -0,0 +1,283 @@
+package filter
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decimal is an exact numeric value that doesn't fit in an int64, like 0.1 or
+// 9223372036854775808. It's bound as its string representation so Postgres can
+// convert it to numeric without losing precision.
+type Decimal string
+
+// Value implements the driver.Valuer interface.
+func (d Decimal) Value() (driver.Value, error) {
+	return string(d), nil
+}
+
+// UUID is a UUID in its canonical lowercase form (e.g. 123e4567-e89b-12d3-a456-426614174000).
+// It's bound as a string which Postgres converts to uuid.
+type UUID string
+
+// Value implements the driver.Valuer interface.
+func (u UUID) Value() (driver.Value, error) {
+	return string(u), nil
+}
+
+// normalizeValue converts values decoded with json.Decoder.UseNumber into the
+// values we bind: integers become int64, everything else becomes a Decimal.
+// MongoDB Extended JSON values like {"$date": "..."} are converted to their Go type.
+func normalizeValue(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return Decimal(v.String()), nil
+	case []any:
+		// Always copy, the filter might be owned by the caller.
+		result := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			result[i], err = normalizeValue(e)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case map[string]any:
+		value, ok, err := extendedJSONValue(v)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return value, nil
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// numberEquals returns true if v is a number from the filter with the value n, before or after
+// normalizeValue, so 1.0 is the same as 1.
+func numberEquals(v any, n int64) bool {
+	var text string
+	switch v := v.(type) {
+	case int64:
+		return v == n
+	case json.Number:
+		text = v.String()
+	case Decimal:
+		text = string(v)
+	default:
+		return false
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	return err == nil && f == float64(n)
+}
+
+// existsValue returns the value of an $exists operator, like MongoDB 0 is false and other
+// numbers are true.
+func existsValue(v any) bool {
+	return v != false && !numberEquals(v, 0)
+}
+
+// jsonbValue returns the JSON text of an array or embedded document from the filter, so
+// it can be compared with a JSONB value. Values in it are converted like other values,
+// except that numbers are kept as JSON numbers.
+func jsonbValue(v any) (string, error) {
+	v, err := jsonValue(v)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func jsonValue(v any) (any, error) {
+	v, err := normalizeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case Decimal:
+		// Marshalling fails for values like NaN, which can't be stored in JSON.
+		return json.Number(v), nil
+	case []any:
+		result := make([]any, len(v))
+		for i, e := range v {
+			if result[i], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, e := range v {
+			if result[key], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	default:
+		return v, nil
+	}
+}
+
+var decimalRegexp = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// extendedJSONValue converts a MongoDB Extended JSON value (canonical or relaxed) into
+// the Go value we bind. ok is false if v isn't an Extended JSON value.
+//
+// See: https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/
+func extendedJSONValue(v map[string]any) (value any, ok bool, err error) {
+	if len(v) != 1 {
+		return nil, false, nil
+	}
+	for key, raw := range v {
+		switch key {
+		case "$date":
+			switch d := raw.(type) {
+			case string:
+				t, err := time.Parse(time.RFC3339Nano, d)
+				if err != nil {
+					return nil, false, fmt.Errorf("invalid value for $date (must be an ISO-8601 date): %v", raw)
+				}
+				return t, true, nil
+			case map[string]any:
+				ms, ok, err := extendedJSONValue(d)
+				if err != nil {
+					return nil, false, err
+				}
+				if i, isInt := ms.(int64); ok && isInt {
+					return time.UnixMilli(i).UTC(), true, nil
+				}
+			case json.Number:
+				if i, err := d.Int64(); err == nil {
+					return time.UnixMilli(i).UTC(), true, nil
+				}
+			case float64:
+				return time.UnixMilli(int64(d)).UTC(), true, nil
+			}
+			return nil, false, fmt.Errorf("invalid value for $date: %v", raw)
+		case "$oid":
+			s, ok := raw.(string)
+			if _, err := hex.DecodeString(s); !ok || len(s) != 24 || err != nil {
+				return nil, false, fmt.Errorf("invalid value for $oid (must be 24 hex characters): %v", raw)
+			}
+			return strings.ToLower(s), true, nil
+		case "$numberInt", "$numberLong":
+			s, _ := raw.(string)
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid value for %s (must be an integer string): %v", key, raw)
+			}
+			return i, true, nil
+		case "$numberDouble":
+			s, _ := raw.(string)
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid value for $numberDouble: %v", raw)
+			}
+			return f, true, nil
+		case "$numberDecimal":
+			s, _ := raw.(string)
+			if !decimalRegexp.MatchString(s) && s != "NaN" && s != "Infinity" && s != "-Infinity" {
+				return nil, false, fmt.Errorf("invalid value for $numberDecimal: %v", raw)
+			}
+			return Decimal(s), true, nil
+		case "$uuid":
+			s, _ := raw.(string)
+			u, err := parseUUID(s)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid value for $uuid: %v", raw)
+			}
+			return u, true, nil
+		case "$binary":
+			// Only UUIDs (subtype 4) can be bound in a meaningful way.
+			b, _ := raw.(map[string]any)
+			data, _ := b["base64"].(string)
+			subType, _ := b["subType"].(string)
+			if subType != "04" && subType != "4" {
+				return nil, false, fmt.Errorf("unsupported $binary subType (only UUID subType 04 is supported): %v", raw)
+			}
+			bytes, err := base64.StdEncoding.DecodeString(data)
+			if err != nil || len(bytes) != 16 {
+				return nil, false, fmt.Errorf("invalid value for $binary UUID: %v", raw)
+			}
+			return formatUUID(bytes), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func parseUUID(s string) (UUID, error) {
+	h := strings.ReplaceAll(s, "-", "")
+	if len(h) != 32 || (len(s) != 32 && len(s) != 36) {
+		return "", fmt.Errorf("invalid UUID: %s", s)
+	}
+	if len(s) == 36 && (s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-') {
+		return "", fmt.Errorf("invalid UUID: %s", s)
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return "", err
+	}
+	return formatUUID(b), nil
+}
+
+func formatUUID(b []byte) UUID {
+	h := hex.EncodeToString(b)
+	return UUID(h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32])
+}
+
+// jsonbCast returns the type a JSONB text value (->>) needs to be cast to before
+// it can be compared with v. An empty string means no cast is needed.
+//
+// See Converter.jsonbCast for the columns this applies to.
+func jsonbCast(v any) string {
+	switch v.(type) {
+	case time.Time:
+		return "timestamptz"
+	case UUID:
+		return "uuid"
+	case bool:
+		return "boolean"
+	}
+	if isNumeric(v) {
+		return "numeric"
+	}
+	return ""
+}
+
+// sliceCast returns the jsonbCast shared by all non-NULL elements of the
+// slice v, or an empty string if they don't share one.
+func sliceCast(v any) string {
+	elements, ok := v.([]any)
+	if !ok {
+		return ""
+	}
+	cast := ""
+	for _, e := range elements {
+		if e == nil {
+			continue
+		}
+		c := jsonbCast(e)
+		if c == "" || (cast != "" && c != cast) {
+			return ""
+		}
+		cast = c
+	}
+	return cast
+}
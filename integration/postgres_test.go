@@ -1,12 +1,16 @@
 package integration
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/lib/pq"
 	"github.com/poki/mongodb-filter-to-postgres/filter"
 )
@@ -429,6 +433,60 @@ func TestIntegration_BasicOperators(t *testing.T) {
 			[]int{3},
 			nil,
 		},
+		{
+			"$not on field",
+			`{"name": {"$not": {"$regex": "^[a-c]"}}}`,
+			[]int{4, 5, 6, 7, 8, 9, 10},
+			nil,
+		},
+		{
+			"$not on field with regular expression",
+			`{"name": {"$not": "/^a/"}}`,
+			[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			nil,
+		},
+		{
+			"$not on field with case insensitive regular expression",
+			`{"name": {"$not": "/^a/i"}}`,
+			[]int{2, 3, 4, 5, 6, 7, 8, 9, 10},
+			nil,
+		},
+		{
+			"$not on jsonb field",
+			`{"pet": {"$not": {"$eq": "cat"}}}`,
+			[]int{1, 3, 5, 7, 9, 10},
+			nil,
+		},
+		{
+			"$not on field with NULL",
+			`{"mount": {"$not": {"$in": ["horse", "dragon"]}}}`,
+			[]int{3, 4, 5, 6, 9, 10},
+			nil,
+		},
+		{
+			"$expr with $add",
+			`{"$expr": {"$gt": ["$level", {"$add": ["$guild_id", 15]}]}}`,
+			[]int{6, 7, 8, 9, 10},
+			nil,
+		},
+		{
+			"$expr with $divide",
+			`{"$expr": {"$gte": [{"$divide": ["$level", "$guild_id"]}, 1.5]}}`,
+			[]int{6, 8, 9, 10},
+			nil,
+		},
+		{
+			"$expr with $size",
+			`{"$expr": {"$or": [{"$eq": [{"$size": "$items"}, 2]}, {"$eq": [{"$size": "$hats"}, 1]}]}}`,
+			[]int{5, 6},
+			nil,
+		},
+		{
+			"$expr with $concat and $toLower",
+			`{"$expr": {"$eq": [{"$concat": [{"$toLower": "$name"}, "-", "$pet"]}, "alice-dog"]}}`,
+			[]int{1},
+			nil,
+		},
 		{
 			// This converts to: ("level" = "metadata"->>'guild_id')
 			// This currently doesn't work, because we don't know the type of the columns.
@@ -680,6 +738,36 @@ func TestIntegration_OrderBy(t *testing.T) {
 			[]int{8, 6, 4, 2, 7, 5, 3, 1, 10, 9}, // "cat" (desc level), then "dog" (desc level), then null/missing pets
 			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level", "class")},
 		},
+		{
+			"nulls first",
+			`{"mount": {"direction": "desc", "nulls": "first"}, "id": 1}`,
+			[]int{3, 4, 9, 10, 1, 2, 5, 6, 7, 8}, // NULL mounts, then phoenix, horse, griffon, dragon
+			[]filter.Option{filter.WithAllowAllColumns()},
+		},
+		{
+			"default nulls first with strings",
+			`{"pet": "asc", "level": "desc"}`,
+			[]int{10, 9, 8, 6, 4, 2, 7, 5, 3, 1}, // null/missing pets (desc level), then "cat" and "dog" (desc level)
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level", "class"), filter.WithDefaultNulls(filter.NullsFirst)},
+		},
+		{
+			"jsonb field with type hint",
+			`{"guild_id": {"direction": -1, "type": "numeric"}, "id": 1}`,
+			[]int{9, 10, 7, 8, 5, 6, 3, 4, 1, 2},
+			[]filter.Option{filter.WithNestedJSONB("metadata", "id", "name", "level", "class")},
+		},
+		{
+			"jsonb field with declared type",
+			`{"guild_id": 1, "id": -1}`,
+			[]int{2, 1, 4, 3, 6, 5, 8, 7, 10, 9},
+			[]filter.Option{filter.WithNestedJSONB("metadata", "id", "name", "level", "class"), filter.WithFieldTypes(map[string]filter.FieldType{"guild_id": filter.FieldTypeNumeric})},
+		},
+		{
+			"type hints on values of another type",
+			`{"pet": {"direction": 1, "type": "numeric"}, "guild_id": {"direction": -1, "type": "boolean"}, "id": 1}`,
+			[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, // all NULL
+			[]filter.Option{filter.WithNestedJSONB("metadata", "id", "name", "level", "class")},
+		},
 	}
 
 	for _, tt := range tests {
@@ -730,3 +818,1438 @@ func TestIntegration_OrderBy(t *testing.T) {
 		})
 	}
 }
+
+func TestIntegration_NumericPrecision(t *testing.T) {
+	db := setupPQ(t)
+
+	if _, err := db.Exec(`
+		CREATE TABLE accounts (
+			"id" bigint PRIMARY KEY,
+			"balance" numeric,
+			"metadata" jsonb
+		);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO accounts ("id", "balance", "metadata")
+		VALUES
+			(9007199254740992, 0.3, '{"external_id": 9007199254740992, "rate": 0.1}'),
+			(9007199254740993, 0.1, '{"external_id": 9007199254740993, "rate": 0.2}'),
+			(9007199254740994, 0.2, '{"external_id": 9007199254740994, "rate": 0.3}')
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		expectedIDs []int64
+	}{
+		{
+			"bigint above 2^53",
+			`{"id": 9007199254740993}`,
+			[]int64{9007199254740993},
+		},
+		{
+			"bigint above 2^53 $in",
+			`{"id": {"$in": [9007199254740993, 9007199254740994]}}`,
+			[]int64{9007199254740993, 9007199254740994},
+		},
+		{
+			"jsonb integer above 2^53",
+			`{"external_id": 9007199254740993}`,
+			[]int64{9007199254740993},
+		},
+		{
+			"jsonb integer above 2^53 $gt",
+			`{"external_id": {"$gt": 9007199254740992}}`,
+			[]int64{9007199254740993, 9007199254740994},
+		},
+		{
+			"exact decimal",
+			`{"balance": 0.1}`,
+			[]int64{9007199254740993},
+		},
+		{
+			"exact jsonb decimal",
+			`{"rate": {"$lte": 0.2}}`,
+			[]int64{9007199254740992, 9007199254740993},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "id", "balance"))
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := queryColumn[int64](t, db, `
+				SELECT id
+				FROM accounts
+				WHERE `+conditions+`
+				ORDER BY id;
+			`, values...)
+
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q)", tt.input, tt.expectedIDs, ids, conditions)
+			}
+		})
+	}
+}
+
+func TestIntegration_Timestamps(t *testing.T) {
+	db := setupPQ(t)
+
+	if _, err := db.Exec(`
+		CREATE TABLE sessions (
+			"id" serial PRIMARY KEY,
+			"metadata" jsonb
+		);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO sessions ("id", "metadata")
+		VALUES
+			(1, '{"last_seen": "2024-04-30T23:59:59Z"}'),
+			(2, '{"last_seen": "2024-05-01T02:00:00+02:00"}'),
+			(3, '{"last_seen": "2024-05-01 12:00:00"}'),
+			(4, '{"last_seen": "2024-06-01T00:00:00.123Z"}'),
+			(5, '{"last_seen": "yesterday"}'),
+			(6, '{"last_seen": 1714521600}'),
+			(7, '{}'),
+			(8, '{"last_seen": "2024-02-31"}'),
+			(9, '{"last_seen": "2023-02-29T10:00Z"}'),
+			(10, '{"last_seen": "0000-01-01"}'),
+			(11, '{"last_seen": "2024-01-01T00:00:00+20:00"}'),
+			(12, '{"last_seen": "2024-02-29"}')
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		input         string
+		orderBy       string
+		expectedIDs   []int
+		expectedOrder []int
+	}{
+		{
+			"$gt",
+			`{"last_seen": {"$gt": "2024-05-01T00:00:00Z"}}`,
+			`{"last_seen": 1}`,
+			[]int{3, 4},
+			[]int{3, 4},
+		},
+		{
+			"$gte with timezone",
+			`{"last_seen": {"$gte": "2024-05-01T00:00:00Z"}}`,
+			`{"last_seen": -1}`,
+			[]int{2, 3, 4},
+			[]int{4, 3, 2},
+		},
+		{
+			"$lt with $date",
+			`{"last_seen": {"$lt": {"$date": "2024-05-01T00:00:00Z"}}}`,
+			`{"last_seen": 1}`,
+			[]int{1, 12},
+			[]int{12, 1},
+		},
+		{
+			"dates that don't exist",
+			`{"last_seen": {"$lt": "2024-04-01"}}`,
+			`{"last_seen": -1}`,
+			[]int{12},
+			[]int{12},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(
+				filter.WithArrayDriver(pq.Array),
+				filter.WithNestedJSONB("metadata", "id"),
+				filter.WithFieldTypes(map[string]filter.FieldType{"last_seen": filter.FieldTypeTimestamp}),
+			)
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			orderBy, err := c.ConvertOrderBy([]byte(tt.orderBy))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, q := range []struct {
+				orderBy  string
+				expected []int
+			}{
+				{"id", tt.expectedIDs},
+				{orderBy, tt.expectedOrder},
+			} {
+				ids := queryColumn[int](t, db, `
+					SELECT id
+					FROM sessions
+					WHERE `+conditions+`
+					ORDER BY `+q.orderBy+`;
+				`, values...)
+
+				if !reflect.DeepEqual(ids, q.expected) {
+					t.Fatalf("%q expected %v, got %v (conditions used: %q, order by: %q)", tt.input, q.expected, ids, conditions, q.orderBy)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegration_Booleans(t *testing.T) {
+	const schema = `
+		CREATE TABLE accounts (
+			"id" serial PRIMARY KEY,
+			"metadata" jsonb
+		);
+		INSERT INTO accounts ("id", "metadata")
+		VALUES
+			(1, '{"verified": true,   "flags": [true]}'),
+			(2, '{"verified": false,  "flags": [false]}'),
+			(3, '{"verified": "true", "flags": ["true"]}'),
+			(4, '{"verified": null,   "flags": []}'),
+			(5, '{}');
+	`
+
+	tests := []struct {
+		name        string
+		input       string
+		expectedIDs []int
+	}{
+		{
+			"$eq",
+			`{"verified": true}`,
+			[]int{1},
+		},
+		{
+			"$eq false",
+			`{"verified": {"$eq": false}}`,
+			[]int{2},
+		},
+		{
+			"$ne",
+			`{"verified": {"$ne": true}}`,
+			[]int{2},
+		},
+		{
+			"$in",
+			`{"verified": {"$in": [true, false]}}`,
+			[]int{1, 2},
+		},
+		{
+			"$elemMatch",
+			`{"flags": {"$elemMatch": {"$eq": true}}}`,
+			[]int{1},
+		},
+	}
+
+	c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "id"))
+	pgxConverter, _ := filter.NewConverter(filter.WithNestedJSONB("metadata", "id"))
+
+	t.Run("pq", func(t *testing.T) {
+		db := setupPQ(t)
+		if _, err := db.Exec(schema); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conditions, values, err := c.Convert([]byte(tt.input), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids := queryColumn[int](t, db, `SELECT id FROM accounts WHERE `+conditions+` ORDER BY id;`, values...)
+				if !reflect.DeepEqual(ids, tt.expectedIDs) {
+					t.Fatalf("%q expected %v, got %v (conditions used: %q)", tt.input, tt.expectedIDs, ids, conditions)
+				}
+			})
+		}
+	})
+
+	t.Run("pgx", func(t *testing.T) {
+		db := setupPGX(t)
+		ctx := context.Background()
+		if _, err := db.Exec(ctx, schema); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conditions, values, err := pgxConverter.Convert([]byte(tt.input), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows, err := db.Query(ctx, `SELECT id FROM accounts WHERE `+conditions+` ORDER BY id;`, values...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(ids, tt.expectedIDs) {
+					t.Fatalf("%q expected %v, got %v (conditions used: %q)", tt.input, tt.expectedIDs, ids, conditions)
+				}
+			})
+		}
+	})
+}
+
+func TestIntegration_JSONBContainment(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	if _, err := db.Exec(`CREATE INDEX players_metadata_idx ON players USING GIN ("metadata" jsonb_path_ops);`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		input           string
+		expectedPlayers []int
+		usesIndex       bool
+	}{
+		{
+			"implicit equality",
+			`{"pet": "dog"}`,
+			[]int{1, 3, 5, 7},
+			true,
+		},
+		{
+			"$eq with exemption",
+			`{"pet": {"$eq": "cat"}, "class": "mage"}`,
+			[]int{2, 8},
+			true,
+		},
+		{
+			"$in",
+			`{"pet": {"$in": ["cat", "dog"]}, "level": {"$gt": 60}}`,
+			[]int{7, 8},
+			true,
+		},
+		{
+			"numbers fall back",
+			`{"guild_id": 20}`,
+			[]int{1, 2},
+			false,
+		},
+		{
+			"numeric strings fall back",
+			`{"guild_id": "20"}`,
+			[]int{1, 2},
+			false,
+		},
+		{
+			"null falls back",
+			`{"pet": null}`,
+			[]int{10},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "name", "level", "class"), filter.WithJSONBContainment())
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			players := queryColumn[int](t, db, `
+				SELECT id
+				FROM players
+				WHERE `+conditions+`;
+			`, values...)
+
+			if !reflect.DeepEqual(players, tt.expectedPlayers) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q)", tt.input, tt.expectedPlayers, players, conditions)
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback() //nolint:errcheck
+			if _, err := tx.Exec(`SET LOCAL enable_seqscan = off;`); err != nil {
+				t.Fatal(err)
+			}
+			plan := strings.Join(queryColumn[string](t, tx, `EXPLAIN SELECT id FROM players WHERE `+conditions+`;`, values...), "\n")
+			if usesIndex := strings.Contains(plan, "players_metadata_idx"); usesIndex != tt.usesIndex {
+				t.Fatalf("%q expected index usage %v, got plan:\n%s", tt.input, tt.usesIndex, plan)
+			}
+		})
+	}
+}
+
+func TestIntegration_JSONPath(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name            string
+		input           string
+		expectedPlayers []int
+	}{
+		{
+			"equality",
+			`{"pet": "dog", "guild_id": 20}`,
+			[]int{1},
+		},
+		{
+			"mixed with a column",
+			`{"pet": "cat", "level": {"$gte": 60}}`,
+			[]int{6, 8},
+		},
+		{
+			"$or",
+			`{"$or": [{"guild_id": {"$lte": 20}}, {"pet": null}]}`,
+			[]int{1, 2, 9, 10},
+		},
+		{
+			"$exists",
+			`{"pet": {"$exists": false}}`,
+			[]int{9},
+		},
+		{
+			"$regex",
+			`{"pet": {"$regex": "^D"}}`,
+			[]int{1, 3, 5, 7},
+		},
+		{
+			"$elemMatch",
+			`{"keys": {"$elemMatch": {"$gt": 5}}}`,
+			[]int{3},
+		},
+		{
+			"$in",
+			`{"guild_id": {"$in": [50, 60]}}`,
+			[]int{7, 8, 9, 10},
+		},
+		{
+			"arrays match any element",
+			`{"hats": "cap"}`,
+			[]int{6},
+		},
+		{
+			"$not",
+			`{"$not": {"pet": "cat"}}`,
+			[]int{1, 3, 5, 7, 9, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "name", "level", "class"), filter.WithJSONPath())
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			players := queryColumn[int](t, db, `
+				SELECT id
+				FROM players
+				WHERE `+conditions+`
+				ORDER BY id;
+			`, values...)
+
+			if !reflect.DeepEqual(players, tt.expectedPlayers) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, values: %v)", tt.input, tt.expectedPlayers, players, conditions, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_ImplicitArrayMatching(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name            string
+		options         []filter.Option
+		input           string
+		expectedPlayers []int
+	}{
+		{
+			"array element",
+			nil,
+			`{"hats": "cap"}`,
+			[]int{6},
+		},
+		{
+			"scalar field",
+			nil,
+			`{"pet": "dog"}`,
+			[]int{1, 3, 5, 7},
+		},
+		{
+			"$ne",
+			nil,
+			`{"hats": {"$ne": "cap"}}`,
+			[]int{5},
+		},
+		{
+			"numeric element",
+			nil,
+			`{"keys": 3}`,
+			[]int{2},
+		},
+		{
+			"$gt",
+			nil,
+			`{"keys": {"$gt": 4}}`,
+			[]int{3},
+		},
+		{
+			"$in",
+			nil,
+			`{"keys": {"$in": [1, 6]}}`,
+			[]int{2, 3},
+		},
+		{
+			"$nin",
+			nil,
+			`{"keys": {"$nin": [1]}}`,
+			[]int{3},
+		},
+		{
+			"jsonb containment",
+			[]filter.Option{filter.WithJSONBContainment()},
+			`{"hats": {"$in": ["cap", "helmet"]}, "pet": "cat"}`,
+			[]int{6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := append([]filter.Option{filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "name", "level", "class"), filter.WithImplicitArrayMatching()}, tt.options...)
+			c, _ := filter.NewConverter(options...)
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			players := queryColumn[int](t, db, `
+				SELECT id
+				FROM players
+				WHERE `+conditions+`
+				ORDER BY id;
+			`, values...)
+
+			if !reflect.DeepEqual(players, tt.expectedPlayers) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, values: %v)", tt.input, tt.expectedPlayers, players, conditions, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_ExactEquality(t *testing.T) {
+	db := setupPQ(t)
+
+	if _, err := db.Exec(`
+		CREATE TABLE shapes (
+			"id" int PRIMARY KEY,
+			"tags" text[],
+			"sizes" int[],
+			"metadata" jsonb
+		);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO shapes ("id", "tags", "sizes", "metadata")
+		VALUES
+			(1, '{"red", "round"}', '{1, 2}', '{"position": {"x": 1, "y": 2}, "keys": [1, 3]}'),
+			(2, '{"round", "red"}', '{2, 1}', '{"position": {"y": 2, "x": 1.0}, "keys": [3, 1]}'),
+			(3, '{"blue"}',         '{}',     '{"position": {"x": 1, "y": 2, "z": 3}, "keys": [1]}'),
+			(4, NULL,               NULL,     '{"position": [1, 2]}')
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		expectedIDs []int
+	}{
+		{
+			"text array column",
+			`{"tags": ["red", "round"]}`,
+			[]int{1},
+		},
+		{
+			"int array column $ne",
+			`{"sizes": {"$ne": [1, 2]}}`,
+			[]int{2, 3},
+		},
+		{
+			"empty array",
+			`{"sizes": []}`,
+			[]int{3},
+		},
+		{
+			"jsonb array",
+			`{"keys": [1, 3]}`,
+			[]int{1},
+		},
+		{
+			"jsonb document",
+			`{"position": {"x": 1, "y": 2}}`,
+			[]int{1, 2},
+		},
+		{
+			"jsonb document is not an array",
+			`{"position": {"$eq": [1, 2]}}`,
+			[]int{4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "id", "tags", "sizes"))
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := queryColumn[int](t, db, `
+				SELECT id
+				FROM shapes
+				WHERE `+conditions+`
+				ORDER BY id;
+			`, values...)
+
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, values: %v)", tt.input, tt.expectedIDs, ids, conditions, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_MongoNullSemantics(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name            string
+		input           string
+		expectedPlayers []int
+	}{
+		{
+			"$ne on column with NULL",
+			`{"mount": {"$ne": "horse"}}`,
+			[]int{3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			"$nin on column with NULL",
+			`{"mount": {"$nin": ["horse", "dragon"]}}`,
+			[]int{3, 4, 5, 6, 9, 10},
+		},
+		{
+			"$nin with null",
+			`{"mount": {"$nin": ["horse", null]}}`,
+			[]int{5, 6, 7, 8, 9, 10},
+		},
+		{
+			"$in with null",
+			`{"mount": {"$in": ["horse", null]}}`,
+			[]int{1, 2, 3, 4},
+		},
+		{
+			"$ne on jsonb field with null and missing",
+			`{"pet": {"$ne": "cat"}}`,
+			[]int{1, 3, 5, 7, 9, 10},
+		},
+		{
+			"null on jsonb field",
+			`{"pet": null}`,
+			[]int{9, 10},
+		},
+		{
+			"$ne null on jsonb field",
+			`{"pet": {"$ne": null}}`,
+			[]int{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		{
+			"$nor",
+			`{"$nor": [{"pet": "cat"}, {"mount": "horse"}]}`,
+			[]int{3, 5, 7, 9, 10},
+		},
+		{
+			"$not",
+			`{"$not": {"mount": "horse"}}`,
+			[]int{3, 4, 5, 6, 7, 8, 9, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "name", "level", "class", "mount"), filter.WithMongoNullSemantics())
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			players := queryColumn[int](t, db, `
+				SELECT id
+				FROM players
+				WHERE `+conditions+`
+				ORDER BY id;
+			`, values...)
+
+			if !reflect.DeepEqual(players, tt.expectedPlayers) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, values: %v)", tt.input, tt.expectedPlayers, players, conditions, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_KnownColumns(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	knownColumns := filter.WithKnownColumns("id", "name", "metadata", "level", "class", "mount", "items", "parents")
+
+	tests := []struct {
+		name            string
+		options         []filter.Option
+		input           string
+		expectedPlayers []int
+	}{
+		{
+			"column exists",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level", "class", "mount"), knownColumns},
+			`{"mount": {"$exists": true}, "pet": {"$exists": true}}`,
+			[]int{1, 2, 3, 4, 5, 6, 7, 8, 10},
+		},
+		{
+			"column doesn't exist",
+			[]filter.Option{filter.WithAllowAllColumns(), knownColumns},
+			`{"pet": {"$exists": true}}`,
+			[]int{},
+		},
+		{
+			"not null policy",
+			[]filter.Option{filter.WithNestedJSONB("metadata", "name", "level", "class", "mount"), knownColumns, filter.WithExistsPolicy(filter.ExistsNotNull)},
+			`{"mount": {"$exists": false}}`,
+			[]int{3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(tt.options...)
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			players := queryColumn[int](t, db, `
+				SELECT id
+				FROM players
+				WHERE `+conditions+`
+				ORDER BY id;
+			`, values...)
+
+			if !reflect.DeepEqual(players, tt.expectedPlayers) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, values: %v)", tt.input, tt.expectedPlayers, players, conditions, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_TextSearch(t *testing.T) {
+	db := setupPQ(t)
+
+	if _, err := db.Exec(`
+		CREATE TABLE books (
+			"id" int PRIMARY KEY,
+			"title" text,
+			"metadata" jsonb,
+			"search" tsvector GENERATED ALWAYS AS (to_tsvector('english', "title")) STORED
+		);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO books ("id", "title", "metadata")
+		VALUES
+			(1, 'The Dragon Rider',           '{"summary": "A boy rides a dragon"}'),
+			(2, 'Riders of the Purple Sage', '{"summary": "A western"}'),
+			(3, 'Dragons and Dragon Riders', '{"summary": "Dragons, dragons and more dragons"}'),
+			(4, 'Cooking for Beginners',     NULL)
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		options     []filter.Option
+		input       string
+		sort        string
+		expectedIDs []int
+	}{
+		{
+			"stemmed search",
+			[]filter.Option{filter.WithTextSearch("title")},
+			`{"$text": {"$search": "dragon rider"}}`,
+			`{"id": 1}`,
+			[]int{1, 3},
+		},
+		{
+			"web search syntax",
+			[]filter.Option{filter.WithTextSearch("title")},
+			`{"$text": {"$search": "rider -dragon"}}`,
+			`{"id": 1}`,
+			[]int{2},
+		},
+		{
+			"multiple columns",
+			[]filter.Option{filter.WithTextSearch("title", "summary")},
+			`{"$text": {"$search": "western"}}`,
+			`{"id": 1}`,
+			[]int{2},
+		},
+		{
+			"tsvector column with other conditions",
+			[]filter.Option{filter.WithTextSearchVector("search")},
+			`{"$text": {"$search": "rider"}, "id": {"$gt": 1}}`,
+			`{"id": 1}`,
+			[]int{2, 3},
+		},
+		{
+			"sort on textScore",
+			[]filter.Option{filter.WithTextSearch("title", "summary")},
+			`{"$text": {"$search": "dragons"}}`,
+			`{"score": {"$meta": "textScore"}, "id": 1}`,
+			[]int{3, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := append([]filter.Option{filter.WithNestedJSONB("metadata", "id", "title", "search")}, tt.options...)
+			c, _ := filter.NewConverter(options...)
+			conditions, orderBy, values, err := c.ConvertWithOrderBy([]byte(tt.input), []byte(tt.sort), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := queryColumn[int](t, db, `
+				SELECT id
+				FROM books
+				WHERE `+conditions+`
+				ORDER BY `+orderBy+`;
+			`, values...)
+
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, order by: %q, values: %v)", tt.input, tt.expectedIDs, ids, conditions, orderBy, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_Geo(t *testing.T) {
+	db := setupPostGIS(t)
+
+	if _, err := db.Exec(`
+		CREATE TABLE servers (
+			"id" int PRIMARY KEY,
+			"name" text,
+			"location" geography(Point, 4326),
+			"area" geometry(Polygon, 4326),
+			"pos" point
+		);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO servers ("id", "name", "location", "area", "pos")
+		VALUES
+			(1, 'amsterdam', 'SRID=4326;POINT(4.9041 52.3676)', 'SRID=4326;POLYGON((4 52, 5 52, 5 53, 4 53, 4 52))',         '(1, 1)'),
+			(2, 'utrecht',   'SRID=4326;POINT(5.1214 52.0907)', 'SRID=4326;POLYGON((5 52, 6 52, 6 53, 5 53, 5 52))',         '(5, 5)'),
+			(3, 'paris',     'SRID=4326;POINT(2.3522 48.8566)', 'SRID=4326;POLYGON((2 48, 3 48, 3 49, 2 49, 2 48))',         '(10, 10)'),
+			(4, 'new york',  'SRID=4326;POINT(-74.006 40.7128)', 'SRID=4326;POLYGON((-75 40, -74 40, -74 41, -75 41, -75 40))', NULL)
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		sort        string
+		expectedIDs []int
+	}{
+		{
+			"$near with $maxDistance in meters",
+			`{"location": {"$near": {"$geometry": {"type": "Point", "coordinates": [4.9, 52.37]}, "$maxDistance": 50000}}}`,
+			`{"id": 1}`,
+			[]int{1, 2},
+		},
+		{
+			"$near with $minDistance",
+			`{"location": {"$near": [4.9, 52.37], "$minDistance": 50000, "$maxDistance": 1000000}}`,
+			`{"id": 1}`,
+			[]int{3},
+		},
+		{
+			"$geoWithin $centerSphere",
+			`{"location": {"$geoWithin": {"$centerSphere": [[4.9, 52.37], 0.01]}}}`,
+			`{"id": 1}`,
+			[]int{1, 2},
+		},
+		{
+			"$geoWithin $box",
+			`{"location": {"$geoWithin": {"$box": [[0, 45], [10, 55]]}}}`,
+			`{"id": 1}`,
+			[]int{1, 2, 3},
+		},
+		{
+			"$geoWithin $polygon",
+			`{"area": {"$geoWithin": {"$polygon": [[3.5, 51], [7, 51], [7, 54], [3.5, 54]]}}}`,
+			`{"id": 1}`,
+			[]int{1, 2},
+		},
+		{
+			"$geoIntersects",
+			`{"area": {"$geoIntersects": {"$geometry": {"type": "Point", "coordinates": [5, 52.5]}}}}`,
+			`{"id": 1}`,
+			[]int{1, 2},
+		},
+		{
+			"point $near",
+			`{"pos": {"$near": [0, 0], "$maxDistance": 8}}`,
+			`{"id": 1}`,
+			[]int{1, 2},
+		},
+		{
+			"point $geoWithin $box",
+			`{"pos": {"$geoWithin": {"$box": [[4, 4], [20, 20]]}}}`,
+			`{"id": 1}`,
+			[]int{2, 3},
+		},
+		{
+			"sort on distance",
+			`{"location": {"$near": [2, 48]}}`,
+			`{"location": {"$near": [2, 48]}}`,
+			[]int{3, 2, 1, 4},
+		},
+		{
+			"sort on point distance",
+			`{"pos": {"$geoWithin": {"$center": [[0, 0], 100]}}}`,
+			`{"pos": {"$near": [6, 6]}}`,
+			[]int{2, 3, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{
+				"location": filter.FieldTypeGeography,
+				"area":     filter.FieldTypeGeometry,
+				"pos":      filter.FieldTypePoint,
+			}))
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			orderBy, err := c.ConvertOrderBy([]byte(tt.sort))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := queryColumn[int](t, db, `
+				SELECT id
+				FROM servers
+				WHERE `+conditions+`
+				ORDER BY `+orderBy+`;
+			`, values...)
+
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Fatalf("%q expected %v, got %v (conditions used: %q, order by: %q, values: %v)", tt.input, tt.expectedIDs, ids, conditions, orderBy, values)
+			}
+		})
+	}
+}
+
+func TestIntegration_Keyset(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name string
+		sort string
+	}{
+		{"regular column with NULLs", `{"mount": -1}`},
+		{"multiple columns", `{"class": 1, "level": -1}`},
+		{"jsonb fields", `{"pet": 1, "guild_id": -1}`},
+		{"jsonb field with NULLs and column", `{"pet": -1, "mount": 1}`},
+		{"tiebreaker only", `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "id", "name", "level", "class", "mount"))
+			secret := []byte("secret")
+
+			_, orderBy, _, err := c.ConvertKeyset([]byte(tt.sort), "id", nil, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedIDs := queryColumn[int](t, db, `SELECT id FROM players ORDER BY `+orderBy)
+
+			// Page through the players, 3 at a time.
+			ids := []int{}
+			cursor := ""
+			for page := 0; page < 10; page++ {
+				var after map[string]any
+				if cursor != "" {
+					if after, err = filter.DecodeCursor(secret, cursor); err != nil {
+						t.Fatal(err)
+					}
+				}
+				conditions, values, err := c.Convert([]byte(`{"level": {"$gt": 0}}`), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keysetConditions, orderBy, keysetValues, err := c.ConvertKeyset([]byte(tt.sort), "id", after, 1+len(values))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				rows, err := db.Query(`
+					SELECT id, to_jsonb(players)
+					FROM players
+					WHERE `+conditions+` AND `+keysetConditions+`
+					ORDER BY `+orderBy+`
+					LIMIT 3
+				`, append(values, keysetValues...)...)
+				if err != nil {
+					t.Fatalf("%v (conditions used: %q, values: %v)", err, keysetConditions, keysetValues)
+				}
+				var last []byte
+				for rows.Next() {
+					var id int
+					if err := rows.Scan(&id, &last); err != nil {
+						t.Fatal(err)
+					}
+					ids = append(ids, id)
+				}
+				if err := rows.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if last == nil {
+					break
+				}
+
+				// The cursor contains the columns and the fields of the metadata of the last player.
+				var row map[string]any
+				decoder := json.NewDecoder(bytes.NewReader(last))
+				decoder.UseNumber()
+				if err := decoder.Decode(&row); err != nil {
+					t.Fatal(err)
+				}
+				for key, value := range row["metadata"].(map[string]any) {
+					row[key] = value
+				}
+				delete(row, "metadata")
+				if cursor, err = filter.EncodeCursor(secret, row); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual(ids, expectedIDs) {
+				t.Fatalf("expected %v, got %v", expectedIDs, ids)
+			}
+		})
+	}
+}
+
+func TestIntegration_Find(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name          string
+		input         string
+		expectedNames []string
+	}{
+		{
+			"filter, sort, limit and skip",
+			`{"filter": {"level": {"$gte": 30}}, "sort": {"pet": 1, "level": -1}, "limit": 3, "skip": 1, "projection": {"name": 1}}`,
+			[]string{"Frank", "David", "Grace"},
+		},
+		{
+			"maximum limit",
+			`{"filter": {"class": "warrior"}, "sort": {"name": -1}, "projection": {"name": 1}}`,
+			[]string{"Jack", "Grace", "David", "Alice"},
+		},
+		{
+			"projection of a jsonb field",
+			`{"filter": {"guild_id": 60}, "sort": {"id": 1}, "projection": {"pet": 1}}`,
+			[]string{"", "null"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithArrayDriver(pq.Array), filter.WithNestedJSONB("metadata", "id", "name", "level", "class"), filter.WithMaxLimit(4))
+			find, err := c.ConvertFind([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query(`
+				SELECT `+find.Select+`
+				FROM players
+				WHERE `+find.Conditions+`
+				ORDER BY `+find.OrderBy+`
+				`+find.LimitOffset+`;
+			`, find.Values...)
+			if err != nil {
+				t.Fatalf("%v (find used: %#v)", err, find)
+			}
+			names := []string{}
+			for rows.Next() {
+				var name sql.NullString
+				if err := rows.Scan(&name); err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, name.String)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(names, tt.expectedNames) {
+				t.Fatalf("expected %v, got %v (find used: %#v)", tt.expectedNames, names, find)
+			}
+		})
+	}
+}
+
+func TestIntegration_Projection(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name       string
+		projection string
+		expected   string
+	}{
+		{
+			"inclusion",
+			`{"name": 1, "metadata.pet": 1, "hats": 1, "_id": 0}`,
+			`{"hats": ["helmet"], "name": "Eve", "pet": "dog"}`,
+		},
+		{
+			"exclusion",
+			`{"items": 0, "parents": 0, "metadata.hats": 0, "pet": 0, "class": 0}`,
+			`{"id": 5, "level": 50, "metadata": {"guild_id": 40}, "mount": "griffon", "name": "Eve"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(
+				filter.WithNestedJSONB("metadata", "id", "name", "level", "class", "mount", "items", "parents"),
+				filter.WithKnownColumns("id", "name", "metadata", "level", "class", "mount", "items", "parents"),
+			)
+			selectList, err := c.ConvertProjection([]byte(tt.projection))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var row string
+			if err := db.QueryRow(`
+				SELECT to_jsonb(p)::text
+				FROM (SELECT ` + selectList + ` FROM players WHERE id = 5) p;
+			`).Scan(&row); err != nil {
+				t.Fatalf("%v (select list used: %q)", err, selectList)
+			}
+
+			var got, expected map[string]any
+			if err := json.Unmarshal([]byte(row), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected %s, got %s (select list used: %q)", tt.expected, row, selectList)
+			}
+		})
+	}
+}
+
+func TestIntegration_Update(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name     string
+		update   string
+		expected string
+	}{
+		{
+			"set, inc and unset",
+			`{"$set": {"name": "Eva", "pet": "cat"}, "$inc": {"level": 5, "guild_id": 1}, "$unset": {"mount": "", "hats": ""}}`,
+			`{"name": "Eva", "level": 55, "mount": null, "items": ["staff", "cloak"], "metadata": {"guild_id": 41, "pet": "cat"}}`,
+		},
+		{
+			"min and max",
+			`{"$min": {"level": 60, "guild_id": 10}, "$max": {"score": 3}}`,
+			`{"name": "Eve", "level": 50, "mount": "griffon", "items": ["staff", "cloak"], "metadata": {"guild_id": 10, "pet": "dog", "hats": ["helmet"], "score": 3}}`,
+		},
+		{
+			"push",
+			`{"$push": {"items": {"$each": ["sword", "staff"]}, "hats": "cap", "keys": 1}}`,
+			`{"name": "Eve", "level": 50, "mount": "griffon", "items": ["staff", "cloak", "sword", "staff"], "metadata": {"guild_id": 40, "pet": "dog", "hats": ["helmet", "cap"], "keys": [1]}}`,
+		},
+		{
+			"add to set",
+			`{"$addToSet": {"items": {"$each": ["sword", "staff", "sword"]}, "hats": {"$each": ["helmet", "cap"]}}}`,
+			`{"name": "Eve", "level": 50, "mount": "griffon", "items": ["staff", "cloak", "sword"], "metadata": {"guild_id": 40, "pet": "dog", "hats": ["helmet", "cap"]}}`,
+		},
+		{
+			"pull",
+			`{"$pull": {"items": {"$in": ["staff"]}, "hats": "helmet", "pet": "dog"}}`,
+			`{"name": "Eve", "level": 50, "mount": "griffon", "items": ["cloak"], "metadata": {"guild_id": 40, "pet": "dog", "hats": []}}`,
+		},
+		{
+			"rename",
+			`{"$rename": {"pet": "animal", "missing": "other"}}`,
+			`{"name": "Eve", "level": 50, "mount": "griffon", "items": ["staff", "cloak"], "metadata": {"guild_id": 40, "animal": "dog", "hats": ["helmet"]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(
+				filter.WithArrayDriver(pq.Array),
+				filter.WithNestedJSONB("metadata", "id", "name", "level", "class", "mount", "items", "parents"),
+				filter.WithWritableColumns("name", "level", "mount", "items", "metadata"),
+			)
+			set, values, err := c.ConvertUpdate([]byte(tt.update), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback() //nolint:errcheck
+
+			var row string
+			if err := tx.QueryRow(`
+				UPDATE players SET `+set+` WHERE id = 5
+				RETURNING jsonb_build_object('name', name, 'level', level, 'mount', mount, 'items', items, 'metadata', metadata)::text;
+			`, values...).Scan(&row); err != nil {
+				t.Fatalf("%v (set used: %q)", err, set)
+			}
+
+			var got, expected map[string]any
+			if err := json.Unmarshal([]byte(row), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected %s, got %s (set used: %q)", tt.expected, row, set)
+			}
+		})
+	}
+}
+
+func TestIntegration_Pipeline(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name     string
+		pipeline string
+		expected string
+	}{
+		{
+			"group",
+			`[{"$match": {"guild_id": {"$gte": 30}}}, {"$group": {"_id": "$class", "n": {"$sum": 1}, "avg": {"$avg": "$level"}}}, {"$sort": {"n": -1, "_id": 1}}]`,
+			`[{"_id": "warrior", "n": 3, "avg": 70}, {"_id": "mage", "n": 2, "avg": 65}, {"_id": "rogue", "n": 2, "avg": 75}, {"_id": "dog", "n": 1, "avg": 30}]`,
+		},
+		{
+			"group on nested field",
+			`[{"$group": {"_id": "$pet", "top": {"$max": "$level"}, "guilds": {"$sum": "$guild_id"}}}, {"$sort": {"_id": 1}}]`,
+			`[{"_id": "cat", "top": 80, "guilds": 140}, {"_id": "dog", "top": 70, "guilds": 140}, {"_id": null, "top": 100, "guilds": 120}]`,
+		},
+		{
+			"count",
+			`[{"$match": {"level": {"$gt": 50}}}, {"$count": "total"}]`,
+			`[{"total": 5}]`,
+		},
+		{
+			"sort, skip, limit and project",
+			`[{"$sort": {"level": -1}}, {"$skip": 1}, {"$limit": 2}, {"$project": {"name": 1}}]`,
+			`[{"name": "Ivy"}, {"name": "Hank"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(
+				filter.WithNestedJSONB("metadata", "id", "name", "level", "class", "mount", "items", "parents"),
+				filter.WithTable("players"),
+			)
+			query, values, err := c.ConvertPipeline([]byte(tt.pipeline), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var row string
+			if err := db.QueryRow(`
+				SELECT COALESCE(jsonb_agg(to_jsonb(p) - '__row' ORDER BY __row), '[]')::text
+				FROM (SELECT *, row_number() OVER () AS __row FROM (`+query+`) q) p;
+			`, values...).Scan(&row); err != nil {
+				t.Fatalf("%v (query used: %q)", err, query)
+			}
+
+			var got, expected []map[string]any
+			if err := json.Unmarshal([]byte(row), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected %s, got %s (query used: %q)", tt.expected, row, query)
+			}
+		})
+	}
+}
+
+func TestIntegration_Collation(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	if _, err := db.Exec(`
+		CREATE COLLATION case_insensitive (provider = icu, locale = 'und-u-ks-level2', deterministic = false);
+		UPDATE players SET name = lower(name) WHERE id IN (2, 4);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		tests := []struct {
+			input       string
+			expectedIDs []int
+		}{
+			{`{"name": {"$in": ["alice", "BOB"]}}`, []int{1, 2}},
+			{`{"pet": "DOG", "level": {"$gt": 10}}`, []int{3, 5, 7}},
+			{`{"class": {"$ne": "WARRIOR"}}`, []int{2, 3, 5, 6, 8, 9}},
+		}
+		for _, tt := range tests {
+			c, _ := filter.NewConverter(
+				filter.WithArrayDriver(pq.Array),
+				filter.WithNestedJSONB("metadata", "id", "name", "level", "class", "mount", "items", "parents"),
+				filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText}),
+				filter.WithDefaultCollation("case_insensitive"),
+			)
+			conditions, values, err := c.Convert([]byte(tt.input), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := queryColumn[int](t, db, `SELECT id FROM players WHERE `+conditions+` ORDER BY id;`, values...)
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Fatalf("%s: expected %v, got %v (conditions used: %q)", tt.input, tt.expectedIDs, ids, conditions)
+			}
+		}
+	})
+
+	t.Run("order by", func(t *testing.T) {
+		tests := []struct {
+			orderBy     string
+			expectedIDs []int
+		}{
+			{`{"name": {"direction": 1, "collation": "C"}}`, []int{1, 3, 5, 6, 7, 8, 9, 10, 2, 4}},
+			{`{"name": {"direction": 1, "caseInsensitive": true}}`, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		}
+		for _, tt := range tests {
+			c, _ := filter.NewConverter(filter.WithAllowAllColumns(), filter.WithFieldTypes(map[string]filter.FieldType{"name": filter.FieldTypeText}), filter.WithCollations("C"))
+			orderBy, err := c.ConvertOrderBy([]byte(tt.orderBy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := queryColumn[int](t, db, `SELECT id FROM players ORDER BY `+orderBy+`;`)
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Fatalf("%s: expected %v, got %v (order by used: %q)", tt.orderBy, tt.expectedIDs, ids, orderBy)
+			}
+		}
+	})
+}
+
+func TestIntegration_OrderByWithValues(t *testing.T) {
+	db := setupPQ(t)
+
+	createPlayersTable(t, db)
+
+	tests := []struct {
+		name          string
+		orderBy       string
+		expectedOrder []int
+	}{
+		{
+			"order",
+			`{"class": {"$order": ["mage", "rogue"]}, "id": 1}`,
+			[]int{2, 5, 8, 6, 9, 1, 3, 4, 7, 10},
+		},
+		{
+			"expression",
+			`{"diff": {"$expr": {"$subtract": ["$level", "$guild_id"]}, "direction": -1}, "id": 1}`,
+			[]int{10, 8, 9, 6, 7, 4, 5, 2, 3, 1},
+		},
+		{
+			"size of array column",
+			`{"items": {"$expr": {"$size": "$items"}, "direction": -1}, "id": 1}`,
+			[]int{5, 6, 7, 1, 2, 3, 4, 8, 9, 10},
+		},
+		{
+			"size of JSONB array",
+			`{"hats": {"$expr": {"$size": "$hats"}, "direction": -1}, "id": -1}`,
+			[]int{6, 5, 10, 9, 8, 7, 4, 3, 2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := filter.NewConverter(filter.WithNestedJSONB("metadata", "id", "name", "level", "class", "mount", "items", "parents"))
+			orderBy, values, err := c.ConvertOrderByWithValues([]byte(tt.orderBy), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := queryColumn[int](t, db, `SELECT id FROM players ORDER BY `+orderBy+`;`, values...)
+			if !reflect.DeepEqual(ids, tt.expectedOrder) {
+				t.Fatalf("expected %v, got %v (order by used: %q)", tt.expectedOrder, ids, orderBy)
+			}
+		})
+	}
+}
@@ -56,9 +56,41 @@ func setupPGX(t *testing.T) *pgxpool.Pool {
 	return db
 }
 
+// setupPostGIS starts a Postgres database with the PostGIS extension.
+func setupPostGIS(t *testing.T) *sql.DB {
+	t.Helper()
+
+	var db *sql.DB
+	setupDatabaseImage(t, "postgis/postgis", "15-3.4-alpine", func(dsn string) error {
+		var err error
+		db, err = sql.Open("postgres", dsn)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+		defer cancel()
+		return db.PingContext(ctx)
+	})
+	t.Cleanup(func() {
+		db.Close() //nolint:errcheck
+	})
+
+	if _, err := db.Exec(`CREATE EXTENSION IF NOT EXISTS postgis`); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
 func setupDatabase(t *testing.T, connect func(string) error) {
 	t.Helper()
 
+	setupDatabaseImage(t, "postgres", "15-alpine", connect)
+}
+
+func setupDatabaseImage(t *testing.T, repository, tag string, connect func(string) error) {
+	t.Helper()
+
 	pool, err := dockertest.NewPool("")
 	if err != nil {
 		t.Fatalf("Could not construct pool: %s", err)
@@ -69,8 +101,8 @@ func setupDatabase(t *testing.T, connect func(string) error) {
 		t.Fatalf("Could not connect to Docker: %s", err)
 	}
 	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
-		Repository: "postgres",
-		Tag:        "15-alpine",
+		Repository: repository,
+		Tag:        tag,
 		Env: []string{
 			"POSTGRES_PASSWORD=test",
 			"POSTGRES_USER=test",
@@ -139,3 +171,30 @@ func createPlayersTable(t *testing.T, db *sql.DB) {
 		t.Fatal(err)
 	}
 }
+
+// queryColumn runs query on a *sql.DB or *sql.Tx and returns the values of the first column,
+// usually the ids of the rows.
+func queryColumn[T any](t *testing.T, db interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, query string, args ...any) []T {
+	t.Helper()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%v (query used: %q)", err, query)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	result := []T{}
+	for rows.Next() {
+		var v T
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}